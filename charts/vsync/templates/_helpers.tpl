@@ -30,3 +30,106 @@ Create chart name and version as used by the chart label.
 {{- define "vsync.chart" -}}
 {{- printf "%s-%s" .Chart.Name .Chart.Version | replace "+" "_" | trunc 63 | trimSuffix "-" -}}
 {{- end -}}
+
+{{/*
+Name of the service account vsync runs as, used to log in with kubernetes auth.
+*/}}
+{{- define "vsync.serviceAccountName" -}}
+{{- if .Values.serviceAccount.create -}}
+{{- default (include "vsync.fullname" .) .Values.serviceAccount.name -}}
+{{- else -}}
+{{- default "default" .Values.serviceAccount.name -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+Environment of the vsync container, the config file and how to reach and log
+in to each vault. Tokens and secret ids are read from the vault tokens secret.
+*/}}
+{{- define "vsync.env" -}}
+{{- $fullname := include "vsync.fullname" . -}}
+{{- if .Values.config }}
+- name: VSYNC_CONFIG
+  value: /etc/vsync/config/config.yaml
+{{- end }}
+{{- $sides := list (dict "vault" .Values.vault.source "env" "VAULT_" "key" "") (dict "vault" .Values.vault.destination "env" "DESTINATION_VAULT_" "key" "destination-") -}}
+{{- range $sides }}
+{{- $vault := .vault }}
+{{- $env := .env }}
+{{- $key := .key }}
+- name: {{ $env }}ADDR
+  value: {{ $vault.address | quote }}
+{{- if $vault.namespace }}
+- name: {{ $env }}NAMESPACE
+  value: {{ $vault.namespace | quote }}
+{{- end }}
+{{- if $vault.authMethod }}
+- name: {{ $env }}AUTH_METHOD
+  value: {{ $vault.authMethod | quote }}
+{{- end }}
+{{- if $vault.authMount }}
+- name: {{ $env }}AUTH_MOUNT
+  value: {{ $vault.authMount | quote }}
+{{- end }}
+{{- if $vault.token }}
+- name: {{ $env }}TOKEN
+  valueFrom:
+    secretKeyRef:
+      name: {{ $fullname }}-vault-tokens
+      key: {{ $key }}vault-token
+{{- end }}
+{{- if $vault.wrappedToken }}
+- name: {{ $env }}WRAPPED_TOKEN
+  valueFrom:
+    secretKeyRef:
+      name: {{ $fullname }}-vault-tokens
+      key: {{ $key }}wrapped-token
+{{- end }}
+{{- if $vault.role }}
+- name: {{ $env }}ROLE
+  value: {{ $vault.role | quote }}
+{{- end }}
+{{- if $vault.jwtFile }}
+- name: {{ $env }}JWT_FILE
+  value: {{ $vault.jwtFile | quote }}
+{{- end }}
+{{- if $vault.roleId }}
+- name: {{ $env }}ROLE_ID
+  value: {{ $vault.roleId | quote }}
+{{- end }}
+{{- if $vault.secretId }}
+- name: {{ $env }}SECRET_ID
+  valueFrom:
+    secretKeyRef:
+      name: {{ $fullname }}-vault-tokens
+      key: {{ $key }}secret-id
+{{- end }}
+{{- if $vault.secretIdWrapped }}
+- name: {{ $env }}SECRET_ID_WRAPPED
+  value: "true"
+{{- end }}
+{{- with $vault.tls }}
+{{- if .caCert }}
+- name: {{ $env }}CACERT
+  value: /etc/vsync/tls/{{ $key }}ca.crt
+{{- end }}
+{{- if .serverName }}
+- name: {{ $env }}TLS_SERVER_NAME
+  value: {{ .serverName | quote }}
+{{- end }}
+{{- if .skipVerify }}
+- name: {{ $env }}SKIP_VERIFY
+  value: "true"
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end -}}
+
+{{/*
+True when a ca certificate is given for either vault.
+*/}}
+{{- define "vsync.caCerts" -}}
+{{- if or .Values.vault.source.tls.caCert .Values.vault.destination.tls.caCert -}}
+true
+{{- end -}}
+{{- end -}}
@@ -1,27 +1,42 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/flaccid/vsync"
 	"github.com/flaccid/vsync/config"
+	"github.com/flaccid/vsync/notify"
+	"github.com/flaccid/vsync/runner"
 	"github.com/flaccid/vsync/vault"
 	"github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/pretty"
 	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
 )
 
 var (
-	appConfig *config.AppConfig
-	client    *vault.Client
-	path      string
+	appConfig  *config.AppConfig
+	client     *vault.Client
+	configFile *config.File
+	contexts   *config.Contexts
+	jobRunner  *runner.Runner
+	path       string
+	webhooks   []*notify.Webhook
+)
+
+var (
+	// answers to the questions asked on the terminal, see confirm
+	stdin = bufio.NewReader(os.Stdin)
 )
 
 func beforeApp(c *cli.Context) error {
@@ -37,26 +52,157 @@ func beforeApp(c *cli.Context) error {
 	appConfig = &config.AppConfig{
 		DryRun: c.Bool("dry"),
 		Source: &config.VaultService{
+			AgentSinkFile:   c.String("agent-sink-file"),
+			AuthMethod:      c.String("auth-method"),
+			AuthMount:       c.String("auth-mount"),
+			JWT:             c.String("jwt"),
+			JWTFile:         c.String("jwt-file"),
+			Namespace:       c.String("namespace"),
+			Role:            c.String("role"),
+			RoleID:          c.String("role-id"),
+			RoleIDFile:      c.String("role-id-file"),
+			SecretID:        c.String("secret-id"),
+			SecretIDFile:    c.String("secret-id-file"),
+			SecretIDWrapped: c.Bool("secret-id-wrapped"),
+			TLS: &api.TLSConfig{
+				CACert:        c.String("ca-cert"),
+				CAPath:        c.String("ca-path"),
+				ClientCert:    c.String("client-cert"),
+				ClientKey:     c.String("client-key"),
+				TLSServerName: c.String("tls-server-name"),
+				Insecure:      c.Bool("tls-skip-verify"),
+			},
 			Vault: &api.Config{
 				Address: c.String("vault-addr"),
 			},
-			VaultCredFile:   c.String("credentials-file"),
-			VaultPassword:   c.String("vault-password"),
-			VaultToken:      c.String("vault-token"),
-			VaultUsername:   c.String("vault-username"),
-			VaultEntrypoint: c.String("entrypoint"),
+			VaultCredFile:            c.String("credentials-file"),
+			VaultPassword:            c.String("vault-password"),
+			VaultToken:               c.String("vault-token"),
+			VaultTokenFile:           c.String("vault-token-file"),
+			VaultUsername:            c.String("vault-username"),
+			VaultEntrypoint:          c.String("entrypoint"),
+			WrappedToken:             c.String("wrapped-token"),
+			WrappedTokenCreationPath: c.String("wrapped-token-creation-path"),
 		},
 		Destination: &config.VaultService{
+			AgentSinkFile:   c.String("destination-agent-sink-file"),
+			AuthMethod:      c.String("destination-auth-method"),
+			AuthMount:       c.String("destination-auth-mount"),
+			JWT:             c.String("destination-jwt"),
+			JWTFile:         c.String("destination-jwt-file"),
+			Namespace:       c.String("destination-namespace"),
+			Role:            c.String("destination-role"),
+			RoleID:          c.String("destination-role-id"),
+			RoleIDFile:      c.String("destination-role-id-file"),
+			SecretID:        c.String("destination-secret-id"),
+			SecretIDFile:    c.String("destination-secret-id-file"),
+			SecretIDWrapped: c.Bool("destination-secret-id-wrapped"),
+			TLS: &api.TLSConfig{
+				CACert:        c.String("destination-ca-cert"),
+				CAPath:        c.String("destination-ca-path"),
+				ClientCert:    c.String("destination-client-cert"),
+				ClientKey:     c.String("destination-client-key"),
+				TLSServerName: c.String("destination-tls-server-name"),
+				Insecure:      c.Bool("destination-tls-skip-verify"),
+			},
 			Vault: &api.Config{
 				Address: c.String("destination-vault-addr"),
 			},
-			VaultEntrypoint: c.String("entrypoint"),
-			VaultPassword:   c.String("destination-vault-password"),
-			VaultToken:      c.String("destination-vault-token"),
-			VaultUsername:   c.String("destination-vault-username"),
+			VaultCredFile:            c.String("destination-credentials-file"),
+			VaultEntrypoint:          c.String("entrypoint"),
+			VaultPassword:            c.String("destination-vault-password"),
+			VaultToken:               c.String("destination-vault-token"),
+			VaultTokenFile:           c.String("destination-vault-token-file"),
+			VaultUsername:            c.String("destination-vault-username"),
+			WrappedToken:             c.String("destination-wrapped-token"),
+			WrappedTokenCreationPath: c.String("destination-wrapped-token-creation-path"),
 		},
 	}
-	log.Debug(spew.Sdump(appConfig))
+	appConfig.RecursiveNamespaces = c.Bool("recursive-namespaces")
+	for _, rule := range c.StringSlice("namespace-map") {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("invalid namespace mapping %q, expected source=destination", rule)
+		}
+		appConfig.NamespaceMappings = append(appConfig.NamespaceMappings, &config.NamespaceMapping{
+			Source:      strings.TrimSpace(parts[0]),
+			Destination: strings.TrimSpace(parts[1]),
+		})
+	}
+	appConfig.Settings = settings(c)
+	for _, setting := range config.Masked(appConfig.Settings) {
+		log.Debugf("setting %s=%s (%s)", setting.Name, setting.Value, setting.Source)
+	}
+
+	for _, url := range c.StringSlice("webhook") {
+		webhook := &notify.Webhook{
+			URL:      url,
+			Preset:   c.String("webhook-preset"),
+			Template: c.String("webhook-template"),
+			On:       c.String("notify-on"),
+			Retries:  c.Int("webhook-retries"),
+		}
+		if err := webhook.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	// the config command checks the config file itself
+	if len(c.String("config")) > 0 && c.Args().First() != "config" {
+		configFile, err = config.LoadFile(c.String("config"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Debugf("loaded %v endpoint(s) and %v job(s) from %s", len(configFile.Endpoints), len(configFile.Jobs), configFile.Path)
+	}
+
+	contexts, err = config.LoadContexts(c.String("contexts-file"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobRunner = runner.New(configFile, contexts)
+	jobRunner.Settings = appConfig.Settings
+	jobRunner.DryRun = appConfig.DryRun
+	jobRunner.Journal = c.String("journal")
+	jobRunner.JournalKey = c.String("journal-key")
+	jobRunner.Webhooks = webhooks
+
+	// contexts and the config file are managed, and set up, without connecting to a vault
+	if command := commandName(c); command == "context" || command == "config" || command == "init" {
+		return nil
+	}
+
+	// jobs connect to the endpoints of the config file, not the vaults given by flags
+	if runsJobs(c) {
+		if configFile == nil {
+			log.Fatal("please provide the config file defining the jobs and environments with --config")
+		}
+		return nil
+	}
+
+	// the source is the context given, otherwise the current context unless a vault address is
+	sourceContext, source := c.String("context"), config.SourceFlag
+	if from := commandOption(c, "from", "sync-secret", "ss"); len(from) > 0 {
+		sourceContext = from
+	}
+	if len(sourceContext) < 1 && !configured("vault-addr") {
+		sourceContext, source = contexts.Current, config.SourceFile
+	}
+	if len(sourceContext) > 0 {
+		appConfig.Source, err = contextService(sourceContext, appConfig.Source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		appConfig.Settings = append(appConfig.Settings, &config.Setting{Name: "source-context", Value: sourceContext, Source: source})
+	}
+	if to := commandOption(c, "to", "sync-secret", "ss"); len(to) > 0 {
+		appConfig.Destination, err = contextService(to, appConfig.Destination)
+		if err != nil {
+			log.Fatal(err)
+		}
+		appConfig.Settings = append(appConfig.Settings, &config.Setting{Name: "destination-context", Value: to, Source: config.SourceFlag})
+	}
 
 	appConfig.Source.Client, err = vault.New(appConfig)
 	if err != nil {
@@ -64,7 +210,7 @@ func beforeApp(c *cli.Context) error {
 	}
 	log.Debug("source client", appConfig.Source.Client)
 
-	if len(c.String("destination-vault-addr")) > 0 {
+	if len(appConfig.Destination.Vault.Address) > 0 {
 		appConfig.Destination.Client, err = vault.NewDest(appConfig)
 		if err != nil {
 			log.Fatalf("error creating destination client: %+v", err)
@@ -72,9 +218,459 @@ func beforeApp(c *cli.Context) error {
 		log.Debug(appConfig.Destination.Client)
 	}
 
+	// keep the tokens valid for the whole run, except when only checking health
+	if commandName(c) != "health" {
+		vault.WatchToken("source", appConfig.Source)
+		if appConfig.Destination.Client != nil {
+			vault.WatchToken("destination", appConfig.Destination)
+		}
+	}
+
+	client = &vault.Client{Report: vault.NewReport(appConfig.Job, appConfig.DryRun)}
+	if len(c.String("journal")) > 0 {
+		client.Journal, err = vault.OpenJournal(c.String("journal"), c.String("journal-key"), appConfig.Destination.Client)
+		if err != nil {
+			log.Fatalf("error opening journal: %s", err)
+		}
+	}
+
 	return nil
 }
 
+// secretSettings are the settings whose values are never displayed
+var secretSettings = map[string]bool{
+	"vault-token":                true,
+	"vault-password":             true,
+	"destination-vault-token":    true,
+	"destination-vault-password": true,
+	"secret-id":                  true,
+	"destination-secret-id":      true,
+	"jwt":                        true,
+	"wrapped-token":              true,
+	"destination-wrapped-token":  true,
+	"destination-jwt":            true,
+	"journal-key":                true,
+	"webhook":                    true,
+}
+
+// settings returns the value of every global option and where it came from
+func settings(c *cli.Context) (settings []*config.Setting) {
+	for _, f := range c.App.Flags {
+		names := f.GetName()
+		name := strings.TrimSpace(strings.Split(names, ",")[0])
+
+		var envVar, value string
+		switch flag := f.(type) {
+		case cli.StringFlag:
+			envVar, value = flag.EnvVar, c.String(name)
+		case cli.BoolFlag:
+			envVar, value = flag.EnvVar, fmt.Sprint(c.Bool(name))
+		case cli.IntFlag:
+			envVar, value = flag.EnvVar, fmt.Sprint(c.Int(name))
+		case cli.StringSliceFlag:
+			envVar, value = flag.EnvVar, strings.Join(c.StringSlice(name), ",")
+		default:
+			continue
+		}
+		if name == "help" || name == "version" {
+			continue
+		}
+
+		source := config.SourceDefault
+		switch {
+		case onCommandLine(names):
+			source = config.SourceFlag
+		case len(envVar) > 0 && len(os.Getenv(envVar)) > 0:
+			source = config.SourceEnv
+		}
+
+		settings = append(settings, &config.Setting{
+			Name:   name,
+			Value:  value,
+			Source: source,
+			Secret: secretSettings[name],
+		})
+	}
+
+	return settings
+}
+
+// onCommandLine returns true when any of a flag's comma separated names was given as an argument
+func onCommandLine(names string) bool {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		for _, arg := range os.Args[1:] {
+			// values such as "--auth-method jwt" are not flags
+			if !strings.HasPrefix(arg, "-") {
+				continue
+			}
+			arg = strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+			if arg == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// configured returns true when a global option was given by flag or env var
+func configured(name string) bool {
+	for _, setting := range appConfig.Settings {
+		if setting.Name == name {
+			return setting.Source != config.SourceDefault
+		}
+	}
+
+	return false
+}
+
+// commandName returns the name of the command being run, resolving its aliases
+func commandName(c *cli.Context) string {
+	if command := c.App.Command(c.Args().First()); command != nil {
+		return command.Name
+	}
+
+	return c.Args().First()
+}
+
+// commandOption returns the value of an option of one of the commands,
+// before the command itself has parsed its flags
+func commandOption(c *cli.Context, name string, commands ...string) string {
+	command := c.Args().First()
+	for _, want := range commands {
+		if command != want {
+			continue
+		}
+		args := c.Args().Tail()
+		for i, arg := range args {
+			if !strings.HasPrefix(arg, "-") {
+				continue
+			}
+			parts := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)
+			if parts[0] != name {
+				continue
+			}
+			if len(parts) == 2 {
+				return parts[1]
+			}
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+		}
+	}
+
+	return ""
+}
+
+// contextService returns the vault service of a context, with the entrypoint
+// of the service it replaces
+func contextService(name string, replaces *config.VaultService) (*config.VaultService, error) {
+	service, err := jobRunner.Context(name)
+	if err != nil {
+		return nil, err
+	}
+	service.VaultEntrypoint = replaces.VaultEntrypoint
+
+	return service, nil
+}
+
+// runsJobs returns true when the command runs jobs or promotions from the config
+// file, or generates the policies for them
+func runsJobs(c *cli.Context) bool {
+	command := commandName(c)
+	if command == "daemon" || command == "promote" {
+		return true
+	}
+	if command == "policy" {
+		return configFile != nil
+	}
+	if command != "sync-secrets" {
+		return false
+	}
+	for _, arg := range c.Args().Tail() {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		switch strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0] {
+		case "job", "j", "all-jobs":
+			return true
+		}
+	}
+
+	return false
+}
+
+// runPromotion promotes the secrets of an app from one environment of the config
+// file to another, showing the changes of each key and asking to confirm them
+func runPromotion(c *cli.Context) {
+	app, from, to := c.String("app"), c.String("from"), c.String("to")
+	if len(app) < 1 || len(from) < 1 || len(to) < 1 {
+		log.Fatal("please provide the app to promote with --app and the environments with --from and --to")
+	}
+	if from == to {
+		log.Fatal("please provide different environments to promote from and to")
+	}
+	for _, name := range []string{from, to} {
+		environment, ok := configFile.Environments[name]
+		if !ok || environment == nil {
+			log.Fatalf("environment %s is not defined in %s", name, configFile.Path)
+		}
+		if err := jobRunner.Connect(configFile, environment.Endpoint); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	promoteConfig, err := configFile.PromotionConfig(app, from, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	promoteConfig.DryRun = appConfig.DryRun
+	promoteConfig.Settings = appConfig.Settings
+
+	promoteClient := &vault.Client{Report: vault.NewReport(promoteConfig.Job, promoteConfig.DryRun)}
+	promotion, err := promoteClient.PlanPromotion(promoteConfig, from, to, c.String("secret"), c.StringSlice("key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch c.String("format") {
+	case "json":
+		j, err := vault.ToJson(promotion)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(j))
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SECRET\tKEY\tCHANGE\t"+strings.ToUpper(from)+"\t"+strings.ToUpper(to))
+		for _, s := range promotion.Secrets {
+			for _, key := range s.Keys {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.DestinationPath, key.Name, key.Change, orNone(key.SourceHash), orNone(key.DestinationHash))
+			}
+		}
+		w.Flush()
+	default:
+		log.Fatalf("unknown format %s", c.String("format"))
+	}
+
+	changed := promotion.Changed()
+	if len(changed) < 1 {
+		log.Infof("%s is already up to date in %s", app, to)
+		return
+	}
+	if !promoteConfig.DryRun && !c.Bool("yes") && !confirm(fmt.Sprintf("promote %v secret(s) of %s from %s to %s?", len(changed), app, from, to)) {
+		log.Fatal("promotion cancelled")
+	}
+
+	promoteClient.Journal, err = jobRunner.JobJournal(configFile.Environments[to].Endpoint, promoteConfig.Destination.Client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	promoteClient.Promote(promoteConfig, promotion)
+	promoteClient.Report.Finish()
+	if err := notify.Send(webhooks, promoteClient.Report); err != nil {
+		log.Error(err)
+	}
+	if promoteClient.Report.HasFailures() {
+		log.Fatalf("%v secret path(s) failed", len(promoteClient.Report.Failed))
+	}
+}
+
+// generatePolicies returns the least privilege policies for the jobs, job store
+// and promotions of the config file, one for each endpoint, or without a config
+// file, for syncing from the source vault to the destination vault
+func generatePolicies(c *cli.Context) ([]*vault.Policy, error) {
+	prefix := c.String("name-prefix")
+	journal := ""
+	if location := c.GlobalString("journal"); strings.HasPrefix(location, "vault:") {
+		journal = strings.TrimPrefix(location, "vault:")
+	}
+
+	if configFile == nil {
+		source := vault.NewPolicy(prefix+"source", appConfig.Source)
+		if err := source.Read(appConfig.Source.VaultEntrypoint, "sync-secrets"); err != nil {
+			return nil, fmt.Errorf("source: %s", err)
+		}
+		if appConfig.RecursiveNamespaces {
+			source.Namespaces("recursive namespaces")
+		}
+		if appConfig.Destination.Client == nil {
+			return []*vault.Policy{source}, nil
+		}
+
+		destination := vault.NewPolicy(prefix+"destination", appConfig.Destination)
+		orphans := config.OrphansKeep
+		if c.Bool("remove-orphans") {
+			orphans = config.OrphansRemove
+		}
+		if err := destination.Write(appConfig.Destination.VaultEntrypoint, "sync-secrets", orphans); err != nil {
+			return nil, fmt.Errorf("destination: %s", err)
+		}
+		if len(journal) > 0 {
+			if err := destination.Append(journal, "journal"); err != nil {
+				return nil, fmt.Errorf("destination: %s", err)
+			}
+		}
+		return []*vault.Policy{source, destination}, nil
+	}
+
+	var policies []*vault.Policy
+	byEndpoint := make(map[string]*vault.Policy)
+	policy := func(endpoint string) (*vault.Policy, error) {
+		if p, ok := byEndpoint[endpoint]; ok {
+			return p, nil
+		}
+		if err := jobRunner.Connect(configFile, endpoint); err != nil {
+			return nil, err
+		}
+		service, err := configFile.Service(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		byEndpoint[endpoint] = vault.NewPolicy(prefix+endpoint, service)
+		policies = append(policies, byEndpoint[endpoint])
+		return byEndpoint[endpoint], nil
+	}
+
+	if store := configFile.JobStore; store != nil {
+		if err := jobRunner.LoadJobStore(configFile); err != nil {
+			return nil, err
+		}
+		p, err := policy(store.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.Read(store.Path, "job store"); err != nil {
+			return nil, fmt.Errorf("job store: %s", err)
+		}
+	}
+
+	names := c.StringSlice("job")
+	if len(names) < 1 {
+		names = configFile.JobNames()
+	}
+	for _, name := range names {
+		job, ok := configFile.Jobs[name]
+		if !ok {
+			return nil, fmt.Errorf("job %s is not defined in %s", name, configFile.Path)
+		}
+		reason := "job " + name
+		source, err := policy(job.Source)
+		if err != nil {
+			return nil, err
+		}
+		destination, err := policy(job.Destination)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := source.Read(job.Entrypoint, reason); err != nil {
+			return nil, fmt.Errorf("%s: %s", reason, err)
+		}
+		if job.RecursiveNamespaces {
+			source.Namespaces(reason + " namespaces")
+		}
+		entrypoint := job.DestinationEntrypoint
+		if len(entrypoint) < 1 {
+			entrypoint = job.Entrypoint
+		}
+		orphans := job.Orphans
+		if c.Bool("remove-orphans") {
+			orphans = config.OrphansRemove
+		}
+		if err := destination.Write(entrypoint, reason, orphans); err != nil {
+			return nil, fmt.Errorf("%s: %s", reason, err)
+		}
+		for _, m := range job.Mappings {
+			if err := destination.Write(m.Destination, reason+" mappings", config.OrphansKeep); err != nil {
+				return nil, fmt.Errorf("%s: %s", reason, err)
+			}
+		}
+		if len(journal) > 0 {
+			if err := destination.Append(journal, "journal"); err != nil {
+				return nil, fmt.Errorf("%s: %s", reason, err)
+			}
+		}
+	}
+
+	// the policy covers every app, with + matching the app's folder
+	for _, promotion := range c.StringSlice("promotion") {
+		environments := strings.SplitN(promotion, ":", 2)
+		if len(environments) < 2 {
+			return nil, fmt.Errorf("invalid promotion %q, expected from:to", promotion)
+		}
+		reason := "promote " + environments[0] + " to " + environments[1]
+		var appPaths []string
+		var endpoints []*vault.Policy
+		for _, name := range environments {
+			environment, ok := configFile.Environments[name]
+			if !ok || environment == nil {
+				return nil, fmt.Errorf("environment %s is not defined in %s", name, configFile.Path)
+			}
+			appPath, err := environment.AppPath("+", name)
+			if err != nil {
+				return nil, fmt.Errorf("environment %s: %s", name, err)
+			}
+			p, err := policy(environment.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+			appPaths, endpoints = append(appPaths, appPath), append(endpoints, p)
+		}
+
+		if err := endpoints[0].Read(appPaths[0], reason); err != nil {
+			return nil, fmt.Errorf("%s: %s", reason, err)
+		}
+		if err := endpoints[1].Write(appPaths[1], reason, config.OrphansKeep); err != nil {
+			return nil, fmt.Errorf("%s: %s", reason, err)
+		}
+		if err := endpoints[1].Annotate(appPaths[1], reason+" metadata"); err != nil {
+			return nil, fmt.Errorf("%s: %s", reason, err)
+		}
+		if len(journal) > 0 {
+			if err := endpoints[1].Append(journal, "journal"); err != nil {
+				return nil, fmt.Errorf("%s: %s", reason, err)
+			}
+		}
+	}
+
+	return policies, nil
+}
+
+// confirm asks a yes or no question on the terminal, anything but yes is no
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := stdin.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+
+	return false
+}
+
+// orNone returns a dash for an empty table cell
+func orNone(value string) string {
+	if len(value) < 1 {
+		return "-"
+	}
+
+	return value
+}
+
+// finishRun completes the report of the run, notifies the webhooks
+// and exits non-zero if any secret path failed
+func finishRun() {
+	client.Report.Finish()
+	if err := notify.Send(webhooks, client.Report); err != nil {
+		log.Error(err)
+	}
+	if client.Report.HasFailures() {
+		log.Fatalf("%v secret path(s) failed", len(client.Report.Failed))
+	}
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "vsync"
@@ -134,7 +730,7 @@ func main() {
 				log.Debugf("%s: %s", path, secret.Data)
 				j, err := json.MarshalIndent(secret, "", "    ")
 				if err != nil {
-					log.Fatalf("error marshalling json: ", err.Error())
+					log.Fatalf("error marshalling json: %s", err)
 				}
 				fmt.Printf("%s\n", string(j))
 
@@ -171,7 +767,7 @@ func main() {
 
 				// split out the cmdline secrets into pairs
 				pairs := strings.Split(c.Args()[1], ",")
-				log.Debugf("pairs: ", pairs)
+				log.Debugf("pairs: %v", pairs)
 
 				// iterate through the pairs and assign to the local var
 				for _, pair := range pairs {
@@ -196,8 +792,14 @@ func main() {
 			Name:        "sync-secret",
 			Aliases:     []string{"ss"},
 			Usage:       "syncs a single secret from source to destination vault",
-			UsageText:   "vsync sync-secret [path]",
+			UsageText:   "vsync sync-secret [--from context] [--to context] [path]",
 			Description: "sync a single secret",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from",
+					Usage: "context of the vault to sync from, instead of the source vault"},
+				cli.StringFlag{Name: "to",
+					Usage: "context of the vault to sync to, instead of the destination vault"},
+			},
 			Action: func(c *cli.Context) error {
 				if len(c.Args().First()) < 1 {
 					log.Fatal("please provide a secret path to sync")
@@ -205,8 +807,9 @@ func main() {
 				path = c.Args().First()
 				err := client.SyncSecret(appConfig, path)
 				if err != nil {
-					log.Fatal(err)
+					log.Error(err)
 				}
+				finishRun()
 				return nil
 			},
 		},
@@ -214,23 +817,110 @@ func main() {
 			Name:        "sync-secrets",
 			Aliases:     []string{"s"},
 			Usage:       "syncs all secrets to the destination vault",
-			UsageText:   "vsync sync-secrets",
+			UsageText:   "vsync sync-secrets [--remove-orphans] [--job name | --all-jobs]",
 			Description: "sync all secrets",
 			Flags: []cli.Flag{
 				cli.BoolFlag{Name: "remove-orphans, ro",
 					Usage: "removes orphans in the destination vault after sync"},
+				cli.StringSliceFlag{Name: "job, j",
+					Usage: "runs the named job from the config file, may be repeated"},
+				cli.BoolFlag{Name: "all-jobs",
+					Usage: "runs every job in the config file"},
 			},
 			Action: func(c *cli.Context) error {
-				client.SyncSecrets(appConfig)
-				if c.Bool("remove-orphans") {
-					log.Info("remove orphans in destination vault")
-					log.Info("fetching all secrets in destination vault, please wait...")
-					orphansRemoved, err := client.RemoveOrphans(appConfig, appConfig.Destination.VaultEntrypoint)
-					if err != nil {
+				if c.Bool("all-jobs") || len(c.StringSlice("job")) > 0 {
+					if err := jobRunner.LoadJobStore(configFile); err != nil {
 						log.Fatal(err)
 					}
-					log.Infof("%v orphans successfully removed", len(orphansRemoved))
+					jobRunner.RemoveOrphans = c.Bool("remove-orphans")
+					jobs := c.StringSlice("job")
+					if c.Bool("all-jobs") {
+						jobs = configFile.JobNames()
+					}
+					if err := jobRunner.RunJobs(jobs); err != nil {
+						log.Fatal(err)
+					}
+					return nil
 				}
+
+				err := client.ForEachNamespace(appConfig, func(appConfig *config.AppConfig) error {
+					client.SyncSecrets(appConfig)
+					if c.Bool("remove-orphans") {
+						log.Info("remove orphans in destination vault")
+						log.Info("fetching all secrets in destination vault, please wait...")
+						orphansRemoved, err := client.RemoveOrphans(appConfig, appConfig.Destination.VaultEntrypoint)
+						if err != nil {
+							return err
+						}
+						log.Infof("%v orphans successfully removed", len(orphansRemoved))
+					}
+					return nil
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+				finishRun()
+				return nil
+			},
+		},
+		cli.Command{
+			Name:        "daemon",
+			Usage:       "runs the jobs of the config file on their schedules",
+			UsageText:   "vsync daemon [--job name] [--status-file path]",
+			Description: "run the jobs of the config file with a schedule, or the jobs given, until interrupted, reloading the config file when it changes or on SIGHUP",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{Name: "job, j",
+					Usage: "runs the named job from the config file, may be repeated, defaults to every job with a schedule"},
+				cli.StringFlag{Name: "status-file",
+					Usage:  "keeps the status of each job in this json file, also used to find runs missed while not running",
+					EnvVar: "VSYNC_STATUS_FILE"},
+				cli.DurationFlag{Name: "mount-cache-ttl",
+					Usage:  "how long the mounts of each vault are cached between runs",
+					EnvVar: "VSYNC_MOUNT_CACHE_TTL",
+					Value:  5 * time.Minute},
+				cli.DurationFlag{Name: "reload-interval",
+					Usage:  "how often the config file is checked for changes to reload, 0 to only reload on SIGHUP",
+					EnvVar: "VSYNC_RELOAD_INTERVAL",
+					Value:  10 * time.Second},
+			},
+			Action: func(c *cli.Context) error {
+				jobRunner.Jobs = c.StringSlice("job")
+				jobRunner.StatusFile = c.String("status-file")
+				jobRunner.MountCacheTTL = c.Duration("mount-cache-ttl")
+				jobRunner.ReloadInterval = c.Duration("reload-interval")
+
+				signals := make(chan os.Signal, 1)
+				signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+				if err := jobRunner.RunDaemon(signals); err != nil {
+					log.Fatal(err)
+				}
+				return nil
+			},
+		},
+		cli.Command{
+			Name:        "promote",
+			Usage:       "promotes the secrets of an app from one environment to another",
+			UsageText:   "vsync promote --app name --from environment --to environment [--key name] [--secret path] [--yes]",
+			Description: "compare the secrets of an app in two environments of the config file key by key, showing hashes in place of values, then copy them, or only the keys given, once confirmed",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "app",
+					Usage: "name of the app whose secrets are promoted"},
+				cli.StringFlag{Name: "from",
+					Usage: "environment of the config file to promote from"},
+				cli.StringFlag{Name: "to",
+					Usage: "environment of the config file to promote to"},
+				cli.StringSliceFlag{Name: "key, k",
+					Usage: "only promotes this key of the secrets, may be repeated"},
+				cli.StringFlag{Name: "secret",
+					Usage: "only promotes the secret at this path below the app's path"},
+				cli.BoolFlag{Name: "yes, y",
+					Usage: "promotes without asking to confirm the changes"},
+				cli.StringFlag{Name: "format, f",
+					Usage: "output format of the changes: table|json",
+					Value: "table"},
+			},
+			Action: func(c *cli.Context) error {
+				runPromotion(c)
 				return nil
 			},
 		},
@@ -259,12 +949,19 @@ func main() {
 				if len(appConfig.Destination.Vault.Address) < 1 {
 					log.Fatal("please provide destination vault parameters")
 				}
-				log.Info("fetching all secrets in destination vault, please wait...")
-				orphansRemoved, err := client.RemoveOrphans(appConfig, appConfig.Destination.VaultEntrypoint)
+				err := client.ForEachNamespace(appConfig, func(appConfig *config.AppConfig) error {
+					log.Info("fetching all secrets in destination vault, please wait...")
+					orphansRemoved, err := client.RemoveOrphans(appConfig, appConfig.Destination.VaultEntrypoint)
+					if err != nil {
+						return err
+					}
+					log.Infof("%v orphans successfully removed", len(orphansRemoved))
+					return nil
+				})
 				if err != nil {
 					log.Fatal(err)
 				}
-				log.Infof("%v orphans successfully removed", len(orphansRemoved))
+				finishRun()
 				return nil
 			},
 		},
@@ -295,18 +992,105 @@ func main() {
 			Name:        "health",
 			Aliases:     []string{"hc"},
 			Usage:       "perform a health check",
-			UsageText:   "vsync health",
-			Description: "performs a health check on the vault server",
+			UsageText:   "vsync health [--wait [--timeout 5m]] [--format table|json]",
+			Description: "performs a health check on the source and destination vault servers, exiting 2 if unreachable, 3 if not initialized, 4 if sealed or 5 if standby",
 			Flags: []cli.Flag{
 				cli.BoolFlag{Name: "destination-vault, ds",
-					Usage: "peform operation on the destination vault server"},
+					Usage: "only check the destination vault server"},
+				cli.BoolFlag{Name: "wait, w",
+					Usage: "wait until the vault servers are initialized, unsealed and active"},
+				cli.DurationFlag{Name: "timeout",
+					Usage: "how long to wait for the vault servers to become healthy",
+					Value: 5 * time.Minute},
+				cli.DurationFlag{Name: "interval",
+					Usage: "how often to check health while waiting",
+					Value: 5 * time.Second},
+				cli.StringFlag{Name: "format, f",
+					Usage: "output format: table|json",
+					Value: "table"},
 			},
 			Action: func(c *cli.Context) error {
-				health, err := client.HealthCheck(appConfig, c.Bool("destination-vault"))
-				if err != nil {
-					log.Fatalf("error getting health status: %s", err)
+				var results []*vault.Health
+				var err error
+				switch {
+				case c.Bool("wait"):
+					if c.Bool("destination-vault") && appConfig.Destination.Client == nil {
+						log.Fatal("please provide destination vault parameters")
+					}
+					results, err = client.WaitHealthy(appConfig, c.Bool("destination-vault"), c.Duration("timeout"), c.Duration("interval"))
+					if err != nil {
+						log.Error(err)
+					}
+				case c.Bool("destination-vault"):
+					if appConfig.Destination.Client == nil {
+						log.Fatal("please provide destination vault parameters")
+					}
+					h, _ := client.HealthCheck(appConfig, true)
+					results = []*vault.Health{h}
+				default:
+					results = client.HealthCheckAll(appConfig)
+				}
+
+				for _, h := range results {
+					if !h.Reachable {
+						log.Errorf("%s vault unreachable: %s", h.Target, h.Error)
+					}
+				}
+
+				switch c.String("format") {
+				case "json":
+					j, err := vault.ToJson(results)
+					if err != nil {
+						log.Fatal(err)
+					}
+					fmt.Println(string(j))
+				case "table":
+					w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "TARGET\tADDRESS\tSTATUS\tVERSION\tCLUSTER\tREPLICATION (PERF/DR)")
+					for _, h := range results {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s/%s\n", h.Target, h.Address, h.Status(), h.Version, h.ClusterName, h.ReplicationPerformanceMode, h.ReplicationDRMode)
+					}
+					w.Flush()
+				default:
+					log.Fatalf("unknown format %s", c.String("format"))
+				}
+				os.Exit(vault.HealthCode(results))
+				return nil
+			},
+		},
+		cli.Command{
+			Name:        "doctor",
+			Aliases:     []string{"dr"},
+			Usage:       "runs preflight checks on the source and destination vaults",
+			UsageText:   "vsync doctor [--format table|json]",
+			Description: "checks reachability, tls, seal status, token, mounts and capabilities a sync depends on",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "format, f",
+					Usage: "output format: table|json",
+					Value: "table"},
+			},
+			Action: func(c *cli.Context) error {
+				checks := client.Doctor(appConfig)
+				switch c.String("format") {
+				case "json":
+					j, err := vault.ToJson(checks)
+					if err != nil {
+						log.Fatal(err)
+					}
+					fmt.Println(string(j))
+				case "table":
+					w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "TARGET\tCHECK\tSTATUS\tMESSAGE")
+					for _, check := range checks {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.Target, check.Name, check.Status, check.Message)
+					}
+					w.Flush()
+				default:
+					log.Fatalf("unknown format %s", c.String("format"))
+				}
+				if vault.Failed(checks) {
+					os.Exit(1)
 				}
-				fmt.Println(health)
 				return nil
 			},
 		},
@@ -343,16 +1127,257 @@ func main() {
 				return nil
 			},
 		},
+		cli.Command{
+			Name:        "journal",
+			Aliases:     []string{"j"},
+			Usage:       "operations on the change journal",
+			UsageText:   "vsync --journal [path] --journal-key [key] journal [action]",
+			Description: "change journal",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:        "verify",
+					Usage:       "verifies the hmac chain of the journal",
+					UsageText:   "vsync --journal [path] --journal-key [key] journal verify",
+					Description: "verify the journal has not been tampered with",
+					Action: func(c *cli.Context) error {
+						if client.Journal == nil {
+							log.Fatal("please provide the journal to verify with --journal")
+						}
+						count, head, err := client.Journal.Verify()
+						if err != nil {
+							log.Fatalf("journal verification failed after %v good entries: %s", count, err)
+						}
+						fmt.Printf("journal verified: %v entries, head %s\n", count, head)
+						return nil
+					},
+				},
+			},
+		},
+		cli.Command{
+			Name:        "policy",
+			Usage:       "operations on the vault policies vsync needs",
+			UsageText:   "vsync policy [action]",
+			Description: "least privilege acl policies",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:        "generate",
+					Usage:       "generates the least privilege policies for the jobs of the config file, or the source and destination vaults",
+					UsageText:   "vsync [--config file] policy generate [--job name] [--promotion from:to] [--remove-orphans] [--apply]",
+					Description: "print the acl policy each vault needs, one for each endpoint of the config file, with the paths the jobs, the job store, the promotions given and a journal in vault touch",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{Name: "job, j",
+							Usage: "only covers the named job from the config file, may be repeated"},
+						cli.StringSliceFlag{Name: "promotion",
+							Usage: "covers promoting apps from one environment of the config file to another, from:to, may be repeated"},
+						cli.BoolFlag{Name: "remove-orphans, ro",
+							Usage: "covers removing orphans, as with sync-secrets --remove-orphans"},
+						cli.StringFlag{Name: "name-prefix",
+							Usage: "prefix of the policy names, followed by the endpoint name or source and destination",
+							Value: "vsync-"},
+						cli.BoolFlag{Name: "apply",
+							Usage: "writes the policies to their vaults"},
+					},
+					Action: func(c *cli.Context) error {
+						policies, err := generatePolicies(c)
+						if err != nil {
+							log.Fatal(err)
+						}
+						for i, policy := range policies {
+							if i > 0 {
+								fmt.Println()
+							}
+							fmt.Print(policy.HCL())
+						}
+						if !c.Bool("apply") {
+							return nil
+						}
+						for _, policy := range policies {
+							if err := policy.Apply(); err != nil {
+								log.Fatalf("unable to write the policy %s: %s", policy.Name, err)
+							}
+							log.Infof("policy %s written to %s", policy.Name, policy.Service.Client.Address())
+						}
+						return nil
+					},
+				},
+			},
+		},
+		cli.Command{
+			Name:        "config",
+			Usage:       "operations on the config file",
+			UsageText:   "vsync config [action]",
+			Description: "config file of named endpoints and sync jobs",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:        "validate",
+					Usage:       "validates a config file without contacting any vault",
+					UsageText:   "vsync config validate [path]",
+					Description: "check a config file, defaulting to --config, for unknown keys, undefined endpoints and invalid mappings, filters and schedules",
+					ArgsUsage:   "[path]",
+					Action: func(c *cli.Context) error {
+						path := c.Args().First()
+						if len(path) < 1 {
+							path = c.GlobalString("config")
+						}
+						if len(path) < 1 {
+							log.Fatal("please provide the config file to validate")
+						}
+
+						_, problems, err := config.ValidateFile(path)
+						if err != nil {
+							log.Fatal(err)
+						}
+						for _, problem := range problems {
+							if problem.Line > 0 {
+								fmt.Printf("%s:%d: %s\n", path, problem.Line, problem.Message)
+							} else {
+								fmt.Printf("%s: %s\n", path, problem.Message)
+							}
+						}
+						if len(problems) > 0 {
+							log.Fatalf("%v problem(s) found in %s", len(problems), path)
+						}
+						fmt.Printf("%s is valid\n", path)
+						return nil
+					},
+				},
+				cli.Command{
+					Name:        "schema",
+					Usage:       "prints the json schema of the config file",
+					UsageText:   "vsync config schema",
+					Description: "print the json schema of the config file format, for editors and linters",
+					Action: func(c *cli.Context) error {
+						j, err := vault.ToJson(config.Schema())
+						if err != nil {
+							log.Fatal(err)
+						}
+						fmt.Println(string(j))
+						return nil
+					},
+				},
+			},
+		},
+		cli.Command{
+			Name:        "init",
+			Usage:       "sets up a config file and helm values interactively",
+			UsageText:   "vsync init [--output vsync.yaml] [--helm-values vsync-values.yaml] [--force]",
+			Description: "ask for the source and destination vaults, test them and list their kv mounts to choose the entrypoints from, then write a config file with a job between them and the matching values of the helm chart",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "output, o",
+					Usage: "the config file to write",
+					Value: "vsync.yaml"},
+				cli.StringFlag{Name: "helm-values",
+					Usage: "the helm values file to write",
+					Value: "vsync-values.yaml"},
+				cli.BoolFlag{Name: "force",
+					Usage: "overwrites the files without asking"},
+			},
+			Action: func(c *cli.Context) error {
+				runWizard(c)
+				return nil
+			},
+		},
+		cli.Command{
+			Name:        "context",
+			Aliases:     []string{"ctx"},
+			Usage:       "manages the named vault contexts",
+			UsageText:   "vsync context [action]",
+			Description: "named vaults and their auth settings from the contexts file, the current context is the source vault unless a vault address is given",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:        "list",
+					Aliases:     []string{"ls"},
+					Usage:       "lists the contexts",
+					UsageText:   "vsync context list",
+					Description: "list the contexts, marking the current context",
+					Action: func(c *cli.Context) error {
+						w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+						fmt.Fprintln(w, "CURRENT\tNAME\tADDRESS\tNAMESPACE\tAUTH METHOD")
+						for _, name := range contexts.Names() {
+							endpoint := contexts.Contexts[name]
+							current, method := "", ""
+							if name == contexts.Current {
+								current = "*"
+							}
+							if endpoint.Auth != nil {
+								method = endpoint.Auth.Method
+							}
+							fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", current, name, endpoint.Address, endpoint.Namespace, method)
+						}
+						w.Flush()
+						return nil
+					},
+				},
+				cli.Command{
+					Name:        "use",
+					Usage:       "sets the current context",
+					UsageText:   "vsync context use [name]",
+					Description: "make a context the current context",
+					ArgsUsage:   "[name]",
+					Action: func(c *cli.Context) error {
+						name := c.Args().First()
+						if len(name) < 1 {
+							log.Fatal("please provide the name of the context to use")
+						}
+						if err := contexts.Use(name); err != nil {
+							log.Fatal(err)
+						}
+						fmt.Printf("switched to context %s\n", name)
+						return nil
+					},
+				},
+				cli.Command{
+					Name:        "current",
+					Usage:       "shows the current context",
+					UsageText:   "vsync context current",
+					Description: "print the name of the current context",
+					Action: func(c *cli.Context) error {
+						if len(contexts.Current) < 1 {
+							log.Fatalf("no current context is set in %s", contexts.Path)
+						}
+						fmt.Println(contexts.Current)
+						return nil
+					},
+				},
+			},
+		},
 		cli.Command{
 			Name:        "show-config",
 			Aliases:     []string{"sc"},
 			Usage:       "show a summary of the config",
-			UsageText:   "vsync show-config",
-			Description: "print config",
+			UsageText:   "vsync show-config [--format table|json|yaml]",
+			Description: "print the effective config, where each value came from and derived values, with secrets masked",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "format, f",
+					Usage: "output format: table|json|yaml",
+					Value: "table"},
+			},
 			Action: func(c *cli.Context) error {
-				// TODO: pretty print out config and mask secrets
-				// currently using spew and is insecure
-				log.Info(spew.Sdump(appConfig))
+				settings := append(config.Masked(appConfig.Settings), client.DerivedSettings(appConfig)...)
+
+				switch c.String("format") {
+				case "json":
+					j, err := vault.ToJson(settings)
+					if err != nil {
+						log.Fatal(err)
+					}
+					fmt.Println(string(j))
+				case "yaml":
+					y, err := yaml.Marshal(settings)
+					if err != nil {
+						log.Fatalf("error marshalling yaml: %s", err)
+					}
+					fmt.Print(string(y))
+				case "table":
+					w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+					for _, setting := range settings {
+						fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Name, setting.Value, setting.Source)
+					}
+					w.Flush()
+				default:
+					log.Fatalf("unknown format %s", c.String("format"))
+				}
 				return nil
 			},
 		},
@@ -360,7 +1385,7 @@ func main() {
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "vault-addr,a",
-			Usage:  "url address of the source vault service",
+			Usage:  "url address of the source vault service, or unix:///path/to/socket for a local vault agent listener",
 			Value:  "http://127.0.0.1:8200",
 			EnvVar: "VAULT_ADDR",
 		},
@@ -369,6 +1394,11 @@ func main() {
 			Usage:  "vault token used to authenticate to source vault service",
 			EnvVar: "VAULT_TOKEN",
 		},
+		cli.StringFlag{
+			Name:   "vault-token-file",
+			Usage:  "path to a file containing the source vault token, when no token is given, otherwise the vault cli's token helper or ~/.vault-token is used",
+			EnvVar: "VAULT_TOKEN_FILE",
+		},
 		cli.StringFlag{
 			Name:   "vault-username,u",
 			Usage:  "vault username to use to authenticate to source vault service",
@@ -384,6 +1414,106 @@ func main() {
 			Usage:  "path to a file (json|yaml) containing the username and password for userpass authentication",
 			EnvVar: "VAULT_CREDENTIALS",
 		},
+		cli.StringFlag{
+			Name:   "auth-method",
+			Usage:  "auth method used to log in to the source vault service: token|userpass|approle|kubernetes|jwt|cert|agent, defaults to userpass when a username or credentials file is provided or approle when a role id is",
+			EnvVar: "VAULT_AUTH_METHOD",
+		},
+		cli.StringFlag{
+			Name:   "auth-mount",
+			Usage:  "path the auth method is mounted at on the source vault service, defaults to the auth method name",
+			EnvVar: "VAULT_AUTH_MOUNT",
+		},
+		cli.StringFlag{
+			Name:   "wrapped-token",
+			Usage:  "single use response-wrapping token wrapping a token or approle credentials for the source vault service",
+			EnvVar: "VAULT_WRAPPED_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "wrapped-token-creation-path",
+			Usage:  "path the source wrapped token must have been created by, * matches within a path segment or any suffix at the end",
+			EnvVar: "VAULT_WRAPPED_TOKEN_CREATION_PATH",
+		},
+		cli.StringFlag{
+			Name:   "agent-sink-file",
+			Usage:  "path to the file sink vault agent writes the source vault token to, re-read when it changes",
+			EnvVar: "VAULT_AGENT_SINK_FILE",
+		},
+		cli.StringFlag{
+			Name:   "role",
+			Usage:  "role to log in as to the source vault service with kubernetes or jwt authentication, or the certificate role with cert authentication",
+			EnvVar: "VAULT_ROLE",
+		},
+		cli.StringFlag{
+			Name:   "jwt",
+			Usage:  "jwt used for jwt authentication to the source vault service, e.g. a ci job's oidc token",
+			EnvVar: "VAULT_JWT",
+		},
+		cli.StringFlag{
+			Name:   "jwt-file",
+			Usage:  "path to the jwt used for kubernetes or jwt authentication to the source vault service, kubernetes defaults to the pod's service account token",
+			EnvVar: "VAULT_JWT_FILE",
+		},
+		cli.StringFlag{
+			Name:   "role-id",
+			Usage:  "approle role id used to authenticate to source vault service",
+			EnvVar: "VAULT_ROLE_ID",
+		},
+		cli.StringFlag{
+			Name:   "role-id-file",
+			Usage:  "path to a file containing the approle role id for the source vault service",
+			EnvVar: "VAULT_ROLE_ID_FILE",
+		},
+		cli.StringFlag{
+			Name:   "secret-id",
+			Usage:  "approle secret id used to authenticate to source vault service",
+			EnvVar: "VAULT_SECRET_ID",
+		},
+		cli.StringFlag{
+			Name:   "secret-id-file",
+			Usage:  "path to a file containing the approle secret id for the source vault service",
+			EnvVar: "VAULT_SECRET_ID_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "secret-id-wrapped",
+			Usage:  "the approle secret id is a response-wrapping token to unwrap first",
+			EnvVar: "VAULT_SECRET_ID_WRAPPED",
+		},
+		cli.StringFlag{
+			Name:   "ca-cert",
+			Usage:  "path to a pem encoded ca certificate to verify the source vault service's certificate",
+			EnvVar: "VAULT_CACERT",
+		},
+		cli.StringFlag{
+			Name:   "ca-path",
+			Usage:  "path to a directory of pem encoded ca certificates to verify the source vault service's certificate",
+			EnvVar: "VAULT_CAPATH",
+		},
+		cli.StringFlag{
+			Name:   "client-cert",
+			Usage:  "path to a pem encoded client certificate for tls connections to the source vault service and cert authentication",
+			EnvVar: "VAULT_CLIENT_CERT",
+		},
+		cli.StringFlag{
+			Name:   "client-key",
+			Usage:  "path to the unencrypted pem encoded private key of the client certificate",
+			EnvVar: "VAULT_CLIENT_KEY",
+		},
+		cli.StringFlag{
+			Name:   "tls-server-name",
+			Usage:  "name to use as the sni host when connecting to the source vault service",
+			EnvVar: "VAULT_TLS_SERVER_NAME",
+		},
+		cli.BoolFlag{
+			Name:   "tls-skip-verify",
+			Usage:  "do not verify the source vault service's certificate (insecure)",
+			EnvVar: "VAULT_SKIP_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "namespace,n",
+			Usage:  "vault enterprise namespace of the source vault service every request is made in",
+			EnvVar: "VAULT_NAMESPACE",
+		},
 		cli.StringFlag{
 			Name:   "entrypoint,e",
 			Usage:  "vault entry point path",
@@ -392,7 +1522,7 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:   "destination-vault-addr",
-			Usage:  "destination vault url",
+			Usage:  "destination vault url, or unix:///path/to/socket for a local vault agent listener",
 			EnvVar: "DESTINATION_VAULT_ADDR",
 		},
 		cli.StringFlag{
@@ -400,6 +1530,11 @@ func main() {
 			Usage:  "destination vault token",
 			EnvVar: "DESTINATION_VAULT_TOKEN",
 		},
+		cli.StringFlag{
+			Name:   "destination-vault-token-file",
+			Usage:  "path to a file containing the destination vault token, when no token is given, otherwise the vault cli's token helper or ~/.vault-token is used",
+			EnvVar: "DESTINATION_VAULT_TOKEN_FILE",
+		},
 		cli.StringFlag{
 			Name:   "destination-vault-username",
 			Usage:  "destination vault username",
@@ -410,6 +1545,175 @@ func main() {
 			Usage:  "destination vault password",
 			EnvVar: "DESTINATION_VAULT_PASSWORD",
 		},
+		cli.StringFlag{
+			Name:   "destination-credentials-file",
+			Usage:  "path to a file (json|yaml) containing the username and password for userpass authentication to the destination vault",
+			EnvVar: "DESTINATION_VAULT_CREDENTIALS",
+		},
+		cli.StringFlag{
+			Name:   "destination-auth-method",
+			Usage:  "auth method used to log in to the destination vault service: token|userpass|approle|kubernetes|jwt|cert|agent",
+			EnvVar: "DESTINATION_VAULT_AUTH_METHOD",
+		},
+		cli.StringFlag{
+			Name:   "destination-auth-mount",
+			Usage:  "path the auth method is mounted at on the destination vault service",
+			EnvVar: "DESTINATION_VAULT_AUTH_MOUNT",
+		},
+		cli.StringFlag{
+			Name:   "destination-wrapped-token",
+			Usage:  "single use response-wrapping token wrapping a token or approle credentials for the destination vault service",
+			EnvVar: "DESTINATION_VAULT_WRAPPED_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "destination-wrapped-token-creation-path",
+			Usage:  "path the destination wrapped token must have been created by",
+			EnvVar: "DESTINATION_VAULT_WRAPPED_TOKEN_CREATION_PATH",
+		},
+		cli.StringFlag{
+			Name:   "destination-agent-sink-file",
+			Usage:  "path to the file sink vault agent writes the destination vault token to, re-read when it changes",
+			EnvVar: "DESTINATION_VAULT_AGENT_SINK_FILE",
+		},
+		cli.StringFlag{
+			Name:   "destination-role",
+			Usage:  "role to log in as to the destination vault service with kubernetes, jwt or cert authentication",
+			EnvVar: "DESTINATION_VAULT_ROLE",
+		},
+		cli.StringFlag{
+			Name:   "destination-jwt",
+			Usage:  "jwt used for jwt authentication to the destination vault service",
+			EnvVar: "DESTINATION_VAULT_JWT",
+		},
+		cli.StringFlag{
+			Name:   "destination-jwt-file",
+			Usage:  "path to the jwt used for kubernetes or jwt authentication to the destination vault service",
+			EnvVar: "DESTINATION_VAULT_JWT_FILE",
+		},
+		cli.StringFlag{
+			Name:   "destination-role-id",
+			Usage:  "destination vault approle role id",
+			EnvVar: "DESTINATION_VAULT_ROLE_ID",
+		},
+		cli.StringFlag{
+			Name:   "destination-role-id-file",
+			Usage:  "path to a file containing the destination vault approle role id",
+			EnvVar: "DESTINATION_VAULT_ROLE_ID_FILE",
+		},
+		cli.StringFlag{
+			Name:   "destination-secret-id",
+			Usage:  "destination vault approle secret id",
+			EnvVar: "DESTINATION_VAULT_SECRET_ID",
+		},
+		cli.StringFlag{
+			Name:   "destination-secret-id-file",
+			Usage:  "path to a file containing the destination vault approle secret id",
+			EnvVar: "DESTINATION_VAULT_SECRET_ID_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "destination-secret-id-wrapped",
+			Usage:  "the destination approle secret id is a response-wrapping token to unwrap first",
+			EnvVar: "DESTINATION_VAULT_SECRET_ID_WRAPPED",
+		},
+		cli.StringFlag{
+			Name:   "destination-namespace",
+			Usage:  "vault enterprise namespace of the destination vault service every request is made in",
+			EnvVar: "DESTINATION_VAULT_NAMESPACE",
+		},
+		cli.StringSliceFlag{
+			Name:   "namespace-map",
+			Usage:  "map a source namespace and those below it to a destination namespace, as source=destination, can be repeated",
+			EnvVar: "VSYNC_NAMESPACE_MAP",
+		},
+		cli.BoolFlag{
+			Name:   "recursive-namespaces",
+			Usage:  "also sync every namespace below the source namespace, to the same path below the destination namespace unless mapped",
+			EnvVar: "VSYNC_RECURSIVE_NAMESPACES",
+		},
+		cli.StringFlag{
+			Name:   "destination-ca-cert",
+			Usage:  "path to a pem encoded ca certificate to verify the destination vault service's certificate",
+			EnvVar: "DESTINATION_VAULT_CACERT",
+		},
+		cli.StringFlag{
+			Name:   "destination-ca-path",
+			Usage:  "path to a directory of pem encoded ca certificates to verify the destination vault service's certificate",
+			EnvVar: "DESTINATION_VAULT_CAPATH",
+		},
+		cli.StringFlag{
+			Name:   "destination-client-cert",
+			Usage:  "path to a pem encoded client certificate for tls connections to the destination vault service and cert authentication",
+			EnvVar: "DESTINATION_VAULT_CLIENT_CERT",
+		},
+		cli.StringFlag{
+			Name:   "destination-client-key",
+			Usage:  "path to the unencrypted pem encoded private key of the destination client certificate",
+			EnvVar: "DESTINATION_VAULT_CLIENT_KEY",
+		},
+		cli.StringFlag{
+			Name:   "destination-tls-server-name",
+			Usage:  "name to use as the sni host when connecting to the destination vault service",
+			EnvVar: "DESTINATION_VAULT_TLS_SERVER_NAME",
+		},
+		cli.BoolFlag{
+			Name:   "destination-tls-skip-verify",
+			Usage:  "do not verify the destination vault service's certificate (insecure)",
+			EnvVar: "DESTINATION_VAULT_SKIP_VERIFY",
+		},
+		cli.StringFlag{
+			Name:   "context",
+			Usage:  "context from the contexts file to use as the source vault, defaults to the current context unless a vault address is given",
+			EnvVar: "VSYNC_CONTEXT",
+		},
+		cli.StringFlag{
+			Name:   "contexts-file",
+			Usage:  "path to the yaml file of named vault contexts",
+			EnvVar: "VSYNC_CONTEXTS",
+			Value:  config.DefaultContextsFile(),
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "path to a yaml or hcl config file of named vault endpoints and the sync jobs between them",
+			EnvVar: "VSYNC_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "journal",
+			Usage:  "append a record of every change to this file, or to a kv path in the destination vault with vault:/path",
+			EnvVar: "VSYNC_JOURNAL",
+		},
+		cli.StringFlag{
+			Name:   "journal-key",
+			Usage:  "key used to hmac chain the journal entries",
+			EnvVar: "VSYNC_JOURNAL_KEY",
+		},
+		cli.StringSliceFlag{
+			Name:   "webhook",
+			Usage:  "url to post a notification to after a sync or orphan removal, may be repeated",
+			EnvVar: "VSYNC_WEBHOOKS",
+		},
+		cli.StringFlag{
+			Name:   "webhook-preset",
+			Usage:  "shape of the webhook notification body: json|slack",
+			EnvVar: "VSYNC_WEBHOOK_PRESET",
+			Value:  "json",
+		},
+		cli.StringFlag{
+			Name:   "webhook-template",
+			Usage:  "path to a go template rendering the json webhook notification body, overrides the preset",
+			EnvVar: "VSYNC_WEBHOOK_TEMPLATE",
+		},
+		cli.StringFlag{
+			Name:   "notify-on",
+			Usage:  "notify webhooks on: changes|failures|both",
+			EnvVar: "VSYNC_NOTIFY_ON",
+			Value:  "both",
+		},
+		cli.IntFlag{
+			Name:   "webhook-retries",
+			Usage:  "number of times to retry a failed webhook notification",
+			EnvVar: "VSYNC_WEBHOOK_RETRIES",
+			Value:  3,
+		},
 		cli.BoolFlag{
 			Name:   "dry",
 			Usage:  "dry run",
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/flaccid/vsync/vault"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// wizardEndpoint is an endpoint set up by the init wizard, its secrets are
+// only used to test it and are written as references to env vars
+type wizardEndpoint struct {
+	Name       string
+	Address    string
+	Namespace  string
+	AuthMethod string
+	AuthMount  string
+	Role       string
+	RoleID     string
+	Entrypoint string
+
+	// Env is the prefix of the env vars the secrets are read from, as in the helm chart
+	Env    string
+	Values string
+
+	secret string
+}
+
+// wizardConfig is what the init wizard writes
+type wizardConfig struct {
+	Source      *wizardEndpoint
+	Destination *wizardEndpoint
+	Job         string
+	Schedule    string
+	Orphans     string
+}
+
+// configTemplate is the config file written by the init wizard
+var configTemplate = template.Must(template.New("config").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(`# written by vsync init, check it with: vsync --config {{ "{{" }}file{{ "}}" }} config validate
+endpoints:
+{{- range .Endpoints }}
+  {{ quote .Name }}:
+    address: {{ quote .Address }}
+{{- if .Namespace }}
+    namespace: {{ quote .Namespace }}
+{{- end }}
+    auth:
+      method: {{ .AuthMethod }}
+{{- if .AuthMount }}
+      mount: {{ quote .AuthMount }}
+{{- end }}
+{{- if eq .AuthMethod "token" }}
+      token: env:{{ .Env }}TOKEN
+{{- else if eq .AuthMethod "approle" }}
+      role_id: {{ quote .RoleID }}
+      secret_id: env:{{ .Env }}SECRET_ID
+{{- else if eq .AuthMethod "kubernetes" }}
+      role: {{ quote .Role }}
+{{- end }}
+{{- end }}
+jobs:
+  {{ quote .Job }}:
+    source: {{ quote .Source.Name }}
+    destination: {{ quote .Destination.Name }}
+    entrypoint: {{ quote .Source.Entrypoint }}
+{{- if ne .Source.Entrypoint .Destination.Entrypoint }}
+    destination_entrypoint: {{ quote .Destination.Entrypoint }}
+{{- end }}
+    orphans: {{ .Orphans }}
+    schedule: {{ quote .Schedule }}
+`))
+
+// valuesTemplate is the helm values snippet written by the init wizard, for the vsync chart
+var valuesTemplate = template.Must(template.New("values").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(`# written by vsync init, install with:
+#   helm install vsync charts/vsync -f {{ "{{" }}file{{ "}}" }}{{ range .Endpoints }}{{ if eq .AuthMethod "token" }} --set vault.{{ .Values }}.token=${{ .Env }}TOKEN{{ else if eq .AuthMethod "approle" }} --set vault.{{ .Values }}.secretId=${{ .Env }}SECRET_ID{{ end }}{{ end }}
+workload:
+  type: daemon
+
+config:
+{{ .Config }}
+vault:
+{{- range .Endpoints }}
+  {{ .Values }}:
+    address: {{ quote .Address }}
+{{- if .Namespace }}
+    namespace: {{ quote .Namespace }}
+{{- end }}
+    authMethod: {{ .AuthMethod }}
+{{- if .AuthMount }}
+    authMount: {{ quote .AuthMount }}
+{{- end }}
+{{- if eq .AuthMethod "token" }}
+    # the token is kept in the vault tokens secret, set it with --set
+    token: ""
+{{- else if eq .AuthMethod "approle" }}
+    token: ""
+    roleId: {{ quote .RoleID }}
+    # the secret id is kept in the vault tokens secret, set it with --set
+    secretId: ""
+{{- else if eq .AuthMethod "kubernetes" }}
+    token: ""
+    role: {{ quote .Role }}
+{{- end }}
+{{- end }}
+`))
+
+// runWizard asks for the source and destination vaults and the job between them,
+// testing each vault, then writes the config file and the matching helm values
+func runWizard(c *cli.Context) {
+	output, valuesOutput := c.String("output"), c.String("helm-values")
+	for _, file := range []string{output, valuesOutput} {
+		if _, err := os.Stat(file); err == nil && !c.Bool("force") && !confirm(fmt.Sprintf("%s exists, overwrite it?", file)) {
+			log.Fatal("init cancelled")
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "vsync init sets up a sync job from a source vault to a destination vault")
+	w := &wizardConfig{
+		Source:      wizardVault("source", "VAULT_", "source"),
+		Destination: wizardVault("destination", "DESTINATION_VAULT_", "destination"),
+	}
+	for w.Destination.Name == w.Source.Name {
+		w.Destination.Name = ask("the endpoints need different names, destination endpoint name", w.Destination.Name+"-destination")
+	}
+
+	fmt.Fprintln(os.Stderr)
+	w.Job = ask("job name", "sync")
+	w.Schedule = ask("cron schedule, e.g. @hourly", "*/5 * * * *")
+	w.Orphans = choose("secrets in the destination that are not in the source", []string{config.OrphansKeep, config.OrphansReport, config.OrphansRemove}, config.OrphansKeep)
+
+	data, err := w.render(configTemplate, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(output, bytes.Replace(data, []byte("{{file}}"), []byte(output), 1), 0600); err != nil {
+		log.Fatalf("unable to write %s: %s", output, err)
+	}
+	if _, err := config.LoadFile(output); err != nil {
+		log.Fatal(err)
+	}
+
+	indented := "  " + strings.Replace(strings.TrimSpace(string(data[bytes.IndexByte(data, '\n')+1:])), "\n", "\n  ", -1)
+	values, err := w.render(valuesTemplate, indented)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(valuesOutput, bytes.Replace(values, []byte("{{file}}"), []byte(valuesOutput), 1), 0600); err != nil {
+		log.Fatalf("unable to write %s: %s", valuesOutput, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nwrote %s and %s\n", output, valuesOutput)
+	var secrets []string
+	for _, endpoint := range []*wizardEndpoint{w.Source, w.Destination} {
+		switch endpoint.AuthMethod {
+		case "token":
+			secrets = append(secrets, endpoint.Env+"TOKEN")
+		case "approle":
+			secrets = append(secrets, endpoint.Env+"SECRET_ID")
+		}
+	}
+	if len(secrets) > 0 {
+		fmt.Fprintf(os.Stderr, "the config file reads the secrets from the env vars %s\n", strings.Join(secrets, ", "))
+	}
+	fmt.Fprintf(os.Stderr, "run the job once with: vsync --config %s sync-secrets --job %s\n", output, w.Job)
+	fmt.Fprintf(os.Stderr, "or on its schedule with: vsync --config %s daemon\n", output)
+}
+
+// wizardVault asks for a vault until it can be logged in to, is healthy and its
+// kv mounts can be listed, then for the entrypoint in one of them
+func wizardVault(target, env, values string) *wizardEndpoint {
+	fmt.Fprintf(os.Stderr, "\n%s vault\n", target)
+	e := &wizardEndpoint{Env: env, Values: values, Name: target}
+	address := os.Getenv(env + "ADDR")
+	if len(address) < 1 {
+		address = "https://127.0.0.1:8200"
+	}
+
+	for {
+		e.Name = ask("endpoint name", e.Name)
+		e.Address = ask("address", address)
+		e.Namespace = ask("namespace, empty for none", e.Namespace)
+		e.AuthMethod = choose("auth method", []string{"token", "approle", "kubernetes"}, "token")
+		e.AuthMount, e.Role, e.RoleID, e.secret = "", "", "", ""
+		switch e.AuthMethod {
+		case "token":
+			e.secret = askSecret("token", env+"TOKEN")
+		case "approle":
+			e.AuthMount = ask("auth mount", "approle")
+			e.RoleID = ask("role id", "")
+			e.secret = askSecret("secret id", env+"SECRET_ID")
+		case "kubernetes":
+			e.AuthMount = ask("auth mount", "kubernetes")
+			e.Role = ask("role", "vsync")
+			// outside a pod the login can not be tested, the vault still can be
+			if _, err := os.Stat(vault.ServiceAccountTokenFile); err != nil {
+				fmt.Fprintf(os.Stderr, "there is no service account token at %s to log in with outside a pod\n", vault.ServiceAccountTokenFile)
+				if !confirm("test the vault with a token of your own instead?") {
+					e.Entrypoint = config.CleanPath(ask("entrypoint", "/secret"))
+					return e
+				}
+				e.secret = askSecret("token", env+"TOKEN")
+			}
+		}
+
+		mounts, err := e.test(target)
+		if err == nil {
+			e.Entrypoint = chooseEntrypoint(mounts)
+			return e
+		}
+		fmt.Fprintf(os.Stderr, "%s vault: %s\n", target, err)
+		if !confirm("try again?") {
+			log.Fatal("init cancelled")
+		}
+		address = e.Address
+	}
+}
+
+// test logs in to the vault with the same checks as the health and list-mounts
+// commands, returning its kv mounts
+func (e *wizardEndpoint) test(target string) ([]*vault.KVMount, error) {
+	endpoint := &config.Endpoint{
+		Address:   e.Address,
+		Namespace: e.Namespace,
+		Auth:      &config.EndpointAuth{Method: e.AuthMethod, Mount: e.AuthMount, Role: e.Role, RoleID: e.RoleID},
+	}
+	switch e.AuthMethod {
+	case "token":
+		endpoint.Auth.Token = e.secret
+	case "approle":
+		endpoint.Auth.SecretID = e.secret
+	case "kubernetes":
+		// a token given in place of the pod's tests all but the login
+		if len(e.secret) > 0 {
+			endpoint.Auth = &config.EndpointAuth{Method: "token", Token: e.secret}
+		}
+	}
+	service := endpoint.Service()
+
+	var err error
+	service.Client, err = vault.Connect(service)
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in: %s", err)
+	}
+	health, err := (&vault.Client{}).HealthCheck(&config.AppConfig{Source: service}, false)
+	if err != nil {
+		return nil, fmt.Errorf("unreachable: %s", err)
+	}
+	// a standby forwards the requests to the active node
+	if code := health.Code(); code != vault.HealthCodeOK && code != vault.HealthCodeStandby {
+		return nil, fmt.Errorf("vault is %s", health.Status())
+	}
+	fmt.Fprintf(os.Stderr, "%s vault %s is %s, version %s\n", target, e.Address, health.Status(), health.Version)
+
+	mounts, err := vault.KVMounts(service.Client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the mounts, the token needs read on sys/mounts: %s", err)
+	}
+	if len(mounts) < 1 {
+		return nil, fmt.Errorf("there are no kv mounts")
+	}
+
+	return mounts, nil
+}
+
+// chooseEntrypoint asks for a kv mount and the path in it to sync
+func chooseEntrypoint(mounts []*vault.KVMount) string {
+	fmt.Fprintln(os.Stderr, "kv mounts:")
+	paths := make([]string, len(mounts))
+	for i, mount := range mounts {
+		paths[i] = mount.Path
+		fmt.Fprintf(os.Stderr, "  %d) %s (kv version %s)\n", i+1, mount.Path, mount.Version)
+	}
+	mount := choose("mount", paths, paths[0])
+	below := ask("path below "+mount+", empty for all of it", "")
+
+	return config.CleanPath(mount + "/" + below)
+}
+
+// render executes a wizard template with its endpoints and the config, indented for the values
+func (w *wizardConfig) render(t *template.Template, indentedConfig string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, struct {
+		*wizardConfig
+		Endpoints []*wizardEndpoint
+		Config    string
+	}{w, []*wizardEndpoint{w.Source, w.Destination}, indentedConfig})
+
+	return buf.Bytes(), err
+}
+
+// ask asks a question on the terminal, returning the default for an empty answer
+func ask(question, defaultAnswer string) string {
+	if len(defaultAnswer) > 0 {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, defaultAnswer)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	answer, err := stdin.ReadString('\n')
+	if err != nil && len(answer) < 1 {
+		log.Fatal("init cancelled, no answer")
+	}
+	if answer = strings.TrimSpace(answer); len(answer) > 0 {
+		return answer
+	}
+
+	return defaultAnswer
+}
+
+// askSecret asks for a secret without echoing it on a terminal, it defaults to
+// the env var the config file reads it from
+func askSecret(question, env string) string {
+	if len(os.Getenv(env)) > 0 {
+		question += ", empty to use $" + env
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", question)
+
+	var answer string
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		secret, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.Fatalf("init cancelled: %s", err)
+		}
+		answer = string(secret)
+	} else {
+		line, err := stdin.ReadString('\n')
+		if err != nil && len(line) < 1 {
+			log.Fatal("init cancelled, no answer")
+		}
+		answer = line
+	}
+
+	if answer = strings.TrimSpace(answer); len(answer) > 0 {
+		return answer
+	}
+
+	return os.Getenv(env)
+}
+
+// choose asks for one of the choices, by name or number
+func choose(question string, choices []string, defaultChoice string) string {
+	for {
+		answer := ask(question+" ("+strings.Join(choices, "|")+")", defaultChoice)
+		for i, choice := range choices {
+			if answer == choice || answer == strconv.Itoa(i+1) {
+				return choice
+			}
+		}
+		fmt.Fprintf(os.Stderr, "please answer one of %s\n", strings.Join(choices, ", "))
+	}
+}
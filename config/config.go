@@ -4,21 +4,59 @@ import (
 	"github.com/hashicorp/vault/api"
 )
 
+// VaultService is a vault and how to authenticate to it
 type VaultService struct {
+	AgentSinkFile   string
+	AuthMethod      string
+	AuthMount       string
 	Client          *api.Client
+	JWT             string
+	JWTFile         string
+	Namespace       string
+	Role            string
+	RoleID          string
+	RoleIDFile      string
+	SecretID        string
+	SecretIDFile    string
+	SecretIDWrapped bool
+	TLS             *api.TLSConfig
 	Vault           *api.Config
 	VaultCredFile   string
 	VaultPassword   string
 	VaultToken      string
+	VaultTokenFile  string
 	VaultUsername   string
 	VaultEntrypoint string
+	WrappedToken    string
+	// WrappedTokenCreationPath is the path the wrapped token must be created by
+	WrappedTokenCreationPath string
+}
+
+// NamespaceMapping maps a source namespace, and the namespaces below it,
+// to a destination namespace
+type NamespaceMapping struct {
+	Source      string `yaml:"source" hcl:"source"`
+	Destination string `yaml:"destination" hcl:"destination"`
+}
+
+// PathMapping maps a source secret path, and the paths below it, to a destination path
+type PathMapping struct {
+	Source      string `yaml:"source" hcl:"source"`
+	Destination string `yaml:"destination" hcl:"destination"`
 }
 
 // AppConfig is the global application config
 // which also includes the vault api config
 type AppConfig struct {
-	Destination *VaultService
-	DryRun      bool
-	LogLevel    string
-	Source      *VaultService
+	Destination         *VaultService
+	DryRun              bool
+	Exclude             []string
+	Include             []string
+	Job                 string
+	LogLevel            string
+	NamespaceMappings   []*NamespaceMapping
+	PathMappings        []*PathMapping
+	RecursiveNamespaces bool
+	Settings            []*Setting
+	Source              *VaultService
 }
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultContextsFile is where the contexts are kept, relative to the home directory
+const defaultContextsFile = ".vsync/contexts.yaml"
+
+// Contexts are named vault endpoints for day to day use, kept in a file like
+// a kubeconfig, with the context used when none is given
+type Contexts struct {
+	Current  string               `yaml:"current"`
+	Contexts map[string]*Endpoint `yaml:"contexts"`
+
+	// Path is where the contexts were loaded from
+	Path string `yaml:"-"`
+
+	node *yaml.Node
+}
+
+// DefaultContextsFile returns the path of the contexts file in the home directory
+func DefaultContextsFile() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(homeDir, defaultContextsFile)
+}
+
+// LoadContexts reads the contexts file, there are no contexts if it does not exist
+func LoadContexts(path string) (*Contexts, error) {
+	contexts := &Contexts{Path: path}
+	if len(path) < 1 {
+		return contexts, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return contexts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read contexts file: %s", err)
+	}
+
+	// the document is kept so changing the current context keeps the file's comments
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal(data, node); err != nil {
+		return nil, fmt.Errorf("unable to parse contexts file %s: %s", path, err)
+	}
+	if len(node.Content) < 1 {
+		return contexts, nil
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(contexts); err != nil {
+		return nil, fmt.Errorf("unable to parse contexts file %s: %s", path, err)
+	}
+	contexts.node = node
+
+	for name, endpoint := range contexts.Contexts {
+		if endpoint == nil || len(endpoint.Address) < 1 {
+			return nil, fmt.Errorf("context %s in %s: address is required", name, path)
+		}
+	}
+
+	return contexts, nil
+}
+
+// Names returns the names of the contexts, sorted
+func (c *Contexts) Names() []string {
+	names := make([]string, 0, len(c.Contexts))
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Service returns a new vault service for a named context
+func (c *Contexts) Service(name string) (*VaultService, error) {
+	endpoint, ok := c.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("context %s is not defined in %s", name, c.Path)
+	}
+
+	return endpoint.Service(), nil
+}
+
+// Use makes a context the current context and saves the contexts file
+func (c *Contexts) Use(name string) error {
+	if _, ok := c.Contexts[name]; !ok {
+		return fmt.Errorf("context %s is not defined in %s", name, c.Path)
+	}
+	if c.node == nil || c.node.Content[0].Kind != yaml.MappingNode {
+		return errors.New("the contexts file is not a yaml mapping")
+	}
+
+	c.Current = name
+	root := c.node.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "current" {
+			root.Content[i+1].SetString(name)
+			return c.save()
+		}
+	}
+	key, value := &yaml.Node{}, &yaml.Node{}
+	key.SetString("current")
+	value.SetString(name)
+	// the comment at the top of the file stays there
+	if len(root.Content) > 0 {
+		key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+
+	return c.save()
+}
+
+// save writes the contexts file
+func (c *Contexts) save() error {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.node); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	// contexts may hold credentials
+	return ioutil.WriteFile(c.Path, buf.Bytes(), 0600)
+}
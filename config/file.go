@@ -0,0 +1,358 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// what a job does with secrets in the destination that are not in the source
+const (
+	OrphansKeep   = "keep"
+	OrphansRemove = "remove"
+	OrphansReport = "report"
+)
+
+// what a scheduled job does when it is due while it is still running
+const (
+	ConcurrencyForbid  = "forbid"
+	ConcurrencyReplace = "replace"
+	ConcurrencyAllow   = "allow"
+)
+
+// what a scheduled job does about runs it missed, e.g. while the daemon was down
+const (
+	MissedRunsSkip = "skip"
+	MissedRunsRun  = "run"
+)
+
+// File is a config file of named vault endpoints, the sync jobs between them, where
+// more jobs are kept in a vault and the environments apps are promoted through, in
+// yaml or, with a .hcl extension, hcl
+type File struct {
+	Endpoints    map[string]*Endpoint    `yaml:"endpoints" hcl:"endpoint"`
+	Jobs         map[string]*Job         `yaml:"jobs" hcl:"job"`
+	JobStore     *JobStore               `yaml:"job_store" hcl:"job_store"`
+	Environments map[string]*Environment `yaml:"environments" hcl:"environment"`
+
+	// Path is where the file was loaded from
+	Path string `yaml:"-" hcl:"-"`
+
+	services map[string]*VaultService
+}
+
+// Endpoint is a named vault, how to authenticate to it and the namespace every request is made in
+type Endpoint struct {
+	Address   string        `yaml:"address" hcl:"address"`
+	Namespace string        `yaml:"namespace" hcl:"namespace"`
+	Auth      *EndpointAuth `yaml:"auth" hcl:"auth"`
+	TLS       *EndpointTLS  `yaml:"tls" hcl:"tls"`
+}
+
+// EndpointAuth is how to log in to an endpoint, the same settings as the auth flags
+type EndpointAuth struct {
+	Method                   string `yaml:"method" hcl:"method"`
+	Mount                    string `yaml:"mount" hcl:"mount"`
+	Token                    string `yaml:"token" hcl:"token"`
+	TokenFile                string `yaml:"token_file" hcl:"token_file"`
+	Username                 string `yaml:"username" hcl:"username"`
+	Password                 string `yaml:"password" hcl:"password"`
+	CredentialsFile          string `yaml:"credentials_file" hcl:"credentials_file"`
+	Role                     string `yaml:"role" hcl:"role"`
+	RoleID                   string `yaml:"role_id" hcl:"role_id"`
+	RoleIDFile               string `yaml:"role_id_file" hcl:"role_id_file"`
+	SecretID                 string `yaml:"secret_id" hcl:"secret_id"`
+	SecretIDFile             string `yaml:"secret_id_file" hcl:"secret_id_file"`
+	SecretIDWrapped          bool   `yaml:"secret_id_wrapped" hcl:"secret_id_wrapped"`
+	JWT                      string `yaml:"jwt" hcl:"jwt"`
+	JWTFile                  string `yaml:"jwt_file" hcl:"jwt_file"`
+	AgentSinkFile            string `yaml:"agent_sink_file" hcl:"agent_sink_file"`
+	WrappedToken             string `yaml:"wrapped_token" hcl:"wrapped_token"`
+	WrappedTokenCreationPath string `yaml:"wrapped_token_creation_path" hcl:"wrapped_token_creation_path"`
+}
+
+// EndpointTLS is how to verify an endpoint's certificate and the client certificate to present
+type EndpointTLS struct {
+	CACert     string `yaml:"ca_cert" hcl:"ca_cert"`
+	CAPath     string `yaml:"ca_path" hcl:"ca_path"`
+	ClientCert string `yaml:"client_cert" hcl:"client_cert"`
+	ClientKey  string `yaml:"client_key" hcl:"client_key"`
+	ServerName string `yaml:"server_name" hcl:"server_name"`
+	SkipVerify bool   `yaml:"skip_verify" hcl:"skip_verify"`
+}
+
+// Job syncs the secrets below an entrypoint of the source endpoint to the destination endpoint,
+// on a cron schedule when one is given
+type Job struct {
+	Source                string              `yaml:"source" hcl:"source"`
+	Destination           string              `yaml:"destination" hcl:"destination"`
+	Entrypoint            string              `yaml:"entrypoint" hcl:"entrypoint"`
+	DestinationEntrypoint string              `yaml:"destination_entrypoint" hcl:"destination_entrypoint"`
+	Include               []string            `yaml:"include" hcl:"include"`
+	Exclude               []string            `yaml:"exclude" hcl:"exclude"`
+	Mappings              []*PathMapping      `yaml:"mappings" hcl:"mappings"`
+	Orphans               string              `yaml:"orphans" hcl:"orphans"`
+	RecursiveNamespaces   bool                `yaml:"recursive_namespaces" hcl:"recursive_namespaces"`
+	NamespaceMappings     []*NamespaceMapping `yaml:"namespace_mappings" hcl:"namespace_mappings"`
+	DryRun                bool                `yaml:"dry_run" hcl:"dry_run"`
+	Schedule              string              `yaml:"schedule" hcl:"schedule"`
+	Concurrency           string              `yaml:"concurrency" hcl:"concurrency"`
+	MissedRuns            string              `yaml:"missed_runs" hcl:"missed_runs"`
+}
+
+// JobStore is a kv path of an endpoint where teams keep jobs, each in a secret
+// below the folder of a scope, e.g. /secret/vsync/jobs/payments/db, whose keys
+// are the settings of a job. Who may write to a scope's folder is up to the
+// vault's policies, the scope limits what the jobs written there may touch
+type JobStore struct {
+	Endpoint string               `yaml:"endpoint" hcl:"endpoint"`
+	Path     string               `yaml:"path" hcl:"path"`
+	Scopes   map[string]*JobScope `yaml:"scopes" hcl:"scope"`
+}
+
+// JobScope is what the jobs in a scope's folder may touch: the endpoints they may
+// use, any when none are given, and the paths they may read and write, which
+// may be globs with * matching within a path segment
+type JobScope struct {
+	Endpoints   []string `yaml:"endpoints" hcl:"endpoints"`
+	Source      []string `yaml:"source" hcl:"source"`
+	Destination []string `yaml:"destination" hcl:"destination"`
+}
+
+// Environment is a stage apps are promoted through, the secrets of an app are
+// below a path templated with the app and environment names, e.g.
+// /secret/apps/{{.App}}/{{.Environment}}
+type Environment struct {
+	Endpoint string `yaml:"endpoint" hcl:"endpoint"`
+	Path     string `yaml:"path" hcl:"path"`
+}
+
+// LoadFile reads and checks a config file
+func LoadFile(path string) (*File, error) {
+	f, problems, err := ValidateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		messages := make([]string, len(problems))
+		for i, problem := range problems {
+			messages[i] = problem.String()
+		}
+		return nil, fmt.Errorf("invalid config file %s: %s", path, strings.Join(messages, "; "))
+	}
+
+	return f, nil
+}
+
+// Service returns a new vault service for the endpoint, without a client
+func (e *Endpoint) Service() *VaultService {
+	auth := e.Auth
+	if auth == nil {
+		auth = &EndpointAuth{}
+	}
+	tls := e.TLS
+	if tls == nil {
+		tls = &EndpointTLS{}
+	}
+
+	return &VaultService{
+		AgentSinkFile:   auth.AgentSinkFile,
+		AuthMethod:      auth.Method,
+		AuthMount:       auth.Mount,
+		JWT:             auth.JWT,
+		JWTFile:         auth.JWTFile,
+		Namespace:       e.Namespace,
+		Role:            auth.Role,
+		RoleID:          auth.RoleID,
+		RoleIDFile:      auth.RoleIDFile,
+		SecretID:        auth.SecretID,
+		SecretIDFile:    auth.SecretIDFile,
+		SecretIDWrapped: auth.SecretIDWrapped,
+		TLS: &api.TLSConfig{
+			CACert:        tls.CACert,
+			CAPath:        tls.CAPath,
+			ClientCert:    tls.ClientCert,
+			ClientKey:     tls.ClientKey,
+			TLSServerName: tls.ServerName,
+			Insecure:      tls.SkipVerify,
+		},
+		Vault: &api.Config{
+			Address: e.Address,
+		},
+		VaultCredFile:            auth.CredentialsFile,
+		VaultPassword:            auth.Password,
+		VaultToken:               auth.Token,
+		VaultTokenFile:           auth.TokenFile,
+		VaultUsername:            auth.Username,
+		WrappedToken:             auth.WrappedToken,
+		WrappedTokenCreationPath: auth.WrappedTokenCreationPath,
+	}
+}
+
+// JobNames returns the names of the jobs in the file, sorted
+func (f *File) JobNames() []string {
+	names := make([]string, 0, len(f.Jobs))
+	for name := range f.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// EnvironmentNames returns the names of the environments in the file, sorted
+func (f *File) EnvironmentNames() []string {
+	names := make([]string, 0, len(f.Environments))
+	for name := range f.Environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Service returns the vault service of a named endpoint, the same one
+// each time so jobs using the same endpoint share its client
+func (f *File) Service(name string) (*VaultService, error) {
+	if service, ok := f.services[name]; ok {
+		return service, nil
+	}
+	endpoint, ok := f.Endpoints[name]
+	if !ok {
+		return nil, fmt.Errorf("endpoint %s is not defined", name)
+	}
+
+	service := endpoint.Service()
+	if f.services == nil {
+		f.services = make(map[string]*VaultService)
+	}
+	f.services[name] = service
+
+	return service, nil
+}
+
+// Keep takes over the services of the previous file's endpoints that did not
+// change, so a reloaded file keeps their clients and tokens
+func (f *File) Keep(previous *File) {
+	for name, endpoint := range f.Endpoints {
+		service, ok := previous.services[name]
+		if !ok || !reflect.DeepEqual(endpoint, previous.Endpoints[name]) {
+			continue
+		}
+		if f.services == nil {
+			f.services = make(map[string]*VaultService)
+		}
+		f.services[name] = service
+	}
+}
+
+// Connected returns the services of the endpoints that have a client
+func (f *File) Connected() (services []*VaultService) {
+	for _, name := range sortedKeys(f.Endpoints) {
+		if service, ok := f.services[name]; ok && service.Client != nil {
+			services = append(services, service)
+		}
+	}
+
+	return services
+}
+
+// AppConfig returns the app config of a job, its source and destination are
+// copies of the endpoints' services that share their clients
+func (f *File) AppConfig(name string) (*AppConfig, error) {
+	job, ok := f.Jobs[name]
+	if !ok || job == nil {
+		return nil, fmt.Errorf("job %s is not defined in %s", name, f.Path)
+	}
+
+	source, err := f.Service(job.Source)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := f.Service(job.Destination)
+	if err != nil {
+		return nil, err
+	}
+	if source.Client == nil || destination.Client == nil {
+		return nil, errors.New("the endpoints of job " + name + " are not connected")
+	}
+
+	jobSource, jobDestination := *source, *destination
+	jobSource.VaultEntrypoint = job.Entrypoint
+	jobDestination.VaultEntrypoint = job.DestinationEntrypoint
+	if len(jobDestination.VaultEntrypoint) < 1 {
+		jobDestination.VaultEntrypoint = job.Entrypoint
+	}
+
+	return &AppConfig{
+		Destination:         &jobDestination,
+		DryRun:              job.DryRun,
+		Exclude:             job.Exclude,
+		Include:             job.Include,
+		Job:                 name,
+		NamespaceMappings:   job.NamespaceMappings,
+		PathMappings:        job.Mappings,
+		RecursiveNamespaces: job.RecursiveNamespaces,
+		Source:              &jobSource,
+	}, nil
+}
+
+// PromotionConfig returns the app config to promote an app from one environment
+// to another, its source and destination are copies of the environments' endpoint
+// services, with the app's paths as their entrypoints
+func (f *File) PromotionConfig(app, from, to string) (*AppConfig, error) {
+	services := make([]*VaultService, 2)
+	for i, name := range []string{from, to} {
+		environment, ok := f.Environments[name]
+		if !ok || environment == nil {
+			return nil, fmt.Errorf("environment %s is not defined in %s", name, f.Path)
+		}
+		appPath, err := environment.AppPath(app, name)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %s", name, err)
+		}
+		service, err := f.Service(environment.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if service.Client == nil {
+			return nil, errors.New("the endpoint of environment " + name + " is not connected")
+		}
+
+		copied := *service
+		copied.VaultEntrypoint = appPath
+		services[i] = &copied
+	}
+
+	return &AppConfig{
+		Job:         "promote " + app,
+		Source:      services[0],
+		Destination: services[1],
+	}, nil
+}
+
+// AppPath returns the path of an app's secrets in the environment
+func (e *Environment) AppPath(app, environment string) (string, error) {
+	if len(app) < 1 || strings.Contains("/"+app+"/", "/../") || strings.Contains("/"+app+"/", "/./") {
+		return "", fmt.Errorf("invalid app name %q", app)
+	}
+	t, err := template.New("path").Parse(e.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, struct{ App, Environment string }{app, environment})
+	if err != nil {
+		return "", err
+	}
+
+	return path.Clean("/" + buf.String()), nil
+}
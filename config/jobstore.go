@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScopeNames returns the names of the scopes of the job store, sorted
+func (s *JobStore) ScopeNames() []string {
+	names := make([]string, 0, len(s.Scopes))
+	for name := range s.Scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// AddStoredJobs adds the jobs read from the job store, by their path below it,
+// to the jobs of the file. A job is named by its path, e.g. payments/db, and
+// is only added when it is valid and within the scope of the folder it is in,
+// the problems of the others are returned
+func (f *File) AddStoredJobs(documents map[string]map[string]interface{}) (problems []*Problem) {
+	names := make([]string, 0, len(documents))
+	for name := range documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		job, jobProblems := f.storedJob(name, documents[name])
+		if len(jobProblems) > 0 {
+			problems = append(problems, jobProblems...)
+			continue
+		}
+		if f.Jobs == nil {
+			f.Jobs = make(map[string]*Job)
+		}
+		f.Jobs[name] = job
+	}
+
+	return problems
+}
+
+// storedJob decodes and checks a job from the job store
+func (f *File) storedJob(name string, document map[string]interface{}) (*Job, []*Problem) {
+	problem := func(format string, args ...interface{}) []*Problem {
+		return []*Problem{{Message: "job " + name + ": " + fmt.Sprintf(format, args...)}}
+	}
+
+	folders := strings.SplitN(name, "/", 2)
+	if len(folders) < 2 {
+		return nil, problem("jobs are kept below the folder of a scope")
+	}
+	scope, ok := f.JobStore.Scopes[folders[0]]
+	if !ok || scope == nil {
+		return nil, problem("there is no scope %s in the job store", folders[0])
+	}
+	if _, ok := f.Jobs[name]; ok {
+		return nil, problem("a job with the same name is in %s", f.Path)
+	}
+
+	// the keys are checked as if the job were in the config file
+	var node yaml.Node
+	if err := node.Encode(document); err != nil {
+		return nil, problem("%s", err)
+	}
+	var problems []*Problem
+	walkYAML(&node, reflect.TypeOf(Job{}), name, make(map[string]int), &problems)
+	job := &Job{}
+	if err := node.Decode(job); err != nil {
+		return nil, append(problems, problem("%s", err)...)
+	}
+	if problems = append(problems, f.jobProblems(name, job, "", nil)...); len(problems) > 0 {
+		return nil, problems
+	}
+
+	if err := scope.allows(job); err != nil {
+		return nil, problem("outside the scope of %s: %s", folders[0], err)
+	}
+
+	return job, nil
+}
+
+// allows returns an error when a job uses an endpoint or touches a path the scope does not allow
+func (s *JobScope) allows(job *Job) error {
+	if len(s.Endpoints) > 0 {
+		for _, endpoint := range []string{job.Source, job.Destination} {
+			if !contains(s.Endpoints, endpoint) {
+				return fmt.Errorf("endpoint %s is not allowed", endpoint)
+			}
+		}
+	}
+	if job.RecursiveNamespaces || len(job.NamespaceMappings) > 0 {
+		return fmt.Errorf("jobs may only sync within the namespaces of the endpoints")
+	}
+
+	if !matchAny(s.Source, job.Entrypoint) {
+		return fmt.Errorf("%s may not be synced from", CleanPath(job.Entrypoint))
+	}
+	destinations := []string{job.DestinationEntrypoint}
+	if len(job.DestinationEntrypoint) < 1 {
+		destinations[0] = job.Entrypoint
+	}
+	for _, m := range job.Mappings {
+		destinations = append(destinations, m.Destination)
+	}
+	for _, destination := range destinations {
+		if !matchAny(s.Destination, destination) {
+			return fmt.Errorf("%s may not be written to", CleanPath(destination))
+		}
+	}
+
+	return nil
+}
+
+// matchAny returns true when a path is at or below a path matching any of the patterns
+func matchAny(patterns []string, secretPath string) bool {
+	for _, pattern := range patterns {
+		if MatchPath(pattern, secretPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contains returns true when the value is in the list
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
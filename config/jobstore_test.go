@@ -0,0 +1,101 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJobScopeAllows(t *testing.T) {
+	scope := &JobScope{
+		Endpoints:   []string{"staging", "prod"},
+		Source:      []string{"/secret/payments", "/secret/shared/*/payments"},
+		Destination: []string{"/secret/payments"},
+	}
+
+	tests := []struct {
+		name    string
+		scope   *JobScope
+		job     *Job
+		wantErr string
+	}{
+		{name: "within", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/payments/db"}},
+		{name: "the scope path", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/payments"}},
+		{name: "source pattern", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/shared/eu/payments/db", DestinationEntrypoint: "/secret/payments/eu"}},
+		{name: "endpoint", job: &Job{Source: "staging", Destination: "dr", Entrypoint: "/secret/payments"}, wantErr: "endpoint dr is not allowed"},
+		{name: "any endpoint", scope: &JobScope{Source: []string{"/secret"}, Destination: []string{"/secret"}}, job: &Job{Source: "a", Destination: "b", Entrypoint: "/secret/x"}},
+		{name: "source", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/billing"}, wantErr: "/secret/billing may not be synced from"},
+		{name: "sibling path", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/payments-admin"}, wantErr: "/secret/payments-admin may not be synced from"},
+		{name: "destination entrypoint", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/payments", DestinationEntrypoint: "/secret/billing"}, wantErr: "/secret/billing may not be written to"},
+		{name: "destination defaults to the entrypoint", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/shared/eu/payments"}, wantErr: "/secret/shared/eu/payments may not be written to"},
+		{name: "mapping", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/payments", Mappings: []*PathMapping{{Source: "/secret/payments/db", Destination: "/secret/admin/db"}}}, wantErr: "/secret/admin/db may not be written to"},
+		{name: "namespaces", job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/payments", RecursiveNamespaces: true}, wantErr: "jobs may only sync within the namespaces of the endpoints"},
+		{name: "no paths", scope: &JobScope{}, job: &Job{Source: "staging", Destination: "prod", Entrypoint: "/secret/payments"}, wantErr: "may not be synced from"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := test.scope
+			if s == nil {
+				s = scope
+			}
+			err := s.allows(test.job)
+			if len(test.wantErr) < 1 {
+				if err != nil {
+					t.Errorf("allows() = %s, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("allows() = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddStoredJobs(t *testing.T) {
+	f := &File{
+		Path: "vsync.yaml",
+		Endpoints: map[string]*Endpoint{
+			"staging": {Address: "https://vault.staging.example.com:8200"},
+			"prod":    {Address: "https://vault.example.com:8200"},
+		},
+		Jobs: map[string]*Job{
+			"payments/db": {Source: "staging", Destination: "prod", Entrypoint: "/secret/payments/db"},
+		},
+		JobStore: &JobStore{Endpoint: "prod", Path: "/secret/vsync/jobs", Scopes: map[string]*JobScope{
+			"payments": {Source: []string{"/secret/payments"}, Destination: []string{"/secret/payments"}},
+		}},
+	}
+	job := func(entrypoint string) map[string]interface{} {
+		return map[string]interface{}{"source": "staging", "destination": "prod", "entrypoint": entrypoint}
+	}
+
+	problems := f.AddStoredJobs(map[string]map[string]interface{}{
+		"payments/api":     job("/secret/payments/api"),
+		"payments/billing": job("/secret/billing"),
+		"payments/db":      job("/secret/payments/db"),
+		"payments/typo":    {"source": "staging", "destination": "prod", "entrypoint": "/secret/payments/x", "orphan": "remove"},
+		"billing/api":      job("/secret/billing/api"),
+		"loose":            job("/secret/payments/loose"),
+	})
+
+	if _, ok := f.Jobs["payments/api"]; !ok || len(f.Jobs) != 2 {
+		t.Errorf("jobs %v, want payments/db and the stored payments/api", f.Jobs)
+	}
+	want := []string{
+		"job billing/api: there is no scope billing in the job store",
+		"job loose: jobs are kept below the folder of a scope",
+		"job payments/billing: outside the scope of payments: /secret/billing may not be synced from",
+		"job payments/db: a job with the same name is in vsync.yaml",
+		"orphan",
+	}
+	got := messages(problems)
+	if len(got) != len(want) {
+		t.Fatalf("AddStoredJobs() problems %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Errorf("problem %q, want %q", got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchPath returns true when a glob pattern matches a secret path or a parent
+// of it, * matches within a path segment so /secret/*/db matches
+// /secret/app/db and every secret below it
+func MatchPath(pattern, secretPath string) bool {
+	pattern, secretPath = CleanPath(pattern), CleanPath(secretPath)
+	for p := secretPath; ; p = path.Dir(p) {
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+	}
+}
+
+// UnderPath returns true when a secret path is the parent path or below it
+func UnderPath(secretPath, parent string) bool {
+	secretPath, parent = CleanPath(secretPath), CleanPath(parent)
+
+	return secretPath == parent || parent == "/" || strings.HasPrefix(secretPath, parent+"/")
+}
+
+// CleanPath returns a secret path with a leading and without a trailing slash
+func CleanPath(secretPath string) string {
+	return path.Clean("/" + secretPath)
+}
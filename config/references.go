@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// prefixes of config values that refer to where the value is kept
+const (
+	ReferenceEnv   = "env:"
+	ReferenceFile  = "file:"
+	ReferenceVault = "vault:"
+)
+
+// SecretReader reads a key of the secret at a path in a named vault, for vault: references
+type SecretReader func(vault, secretPath, key string) (string, error)
+
+// Resolve returns the value a config value refers to: env:NAME is an env var,
+// file:/path the contents of a file and vault:<name>:<path>#key a key of a
+// secret in a config file endpoint or context, other values are literals
+func Resolve(value string, read SecretReader) (string, error) {
+	switch {
+	case strings.HasPrefix(value, ReferenceEnv):
+		name := strings.TrimPrefix(value, ReferenceEnv)
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("env var %s is not set", name)
+		}
+		return resolved, nil
+	case strings.HasPrefix(value, ReferenceFile):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(value, ReferenceFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(value, ReferenceVault):
+		parts := strings.SplitN(strings.TrimPrefix(value, ReferenceVault), ":", 2)
+		hash := -1
+		if len(parts) == 2 {
+			hash = strings.LastIndex(parts[1], "#")
+		}
+		if hash < 1 || len(parts[0]) < 1 || hash == len(parts[1])-1 {
+			return "", fmt.Errorf("invalid vault reference %q, expected vault:<name>:<path>#key", value)
+		}
+		if read == nil {
+			return "", fmt.Errorf("vault references are not supported here: %s", value)
+		}
+		return read(parts[0], parts[1][:hash], parts[1][hash+1:])
+	}
+
+	return value, nil
+}
+
+// ResolveService replaces every reference in the settings of a vault service with
+// the value it refers to, the service is only changed once all of them resolve
+func ResolveService(service *VaultService, read SecretReader) error {
+	settings := map[string]*string{
+		"address":                     &service.Vault.Address,
+		"agent_sink_file":             &service.AgentSinkFile,
+		"method":                      &service.AuthMethod,
+		"mount":                       &service.AuthMount,
+		"ca_cert":                     &service.TLS.CACert,
+		"ca_path":                     &service.TLS.CAPath,
+		"client_cert":                 &service.TLS.ClientCert,
+		"client_key":                  &service.TLS.ClientKey,
+		"credentials_file":            &service.VaultCredFile,
+		"jwt":                         &service.JWT,
+		"jwt_file":                    &service.JWTFile,
+		"namespace":                   &service.Namespace,
+		"password":                    &service.VaultPassword,
+		"role":                        &service.Role,
+		"role_id":                     &service.RoleID,
+		"role_id_file":                &service.RoleIDFile,
+		"secret_id":                   &service.SecretID,
+		"secret_id_file":              &service.SecretIDFile,
+		"server_name":                 &service.TLS.TLSServerName,
+		"token":                       &service.VaultToken,
+		"token_file":                  &service.VaultTokenFile,
+		"username":                    &service.VaultUsername,
+		"wrapped_token":               &service.WrappedToken,
+		"wrapped_token_creation_path": &service.WrappedTokenCreationPath,
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resolved := make(map[string]string, len(settings))
+	for _, name := range names {
+		value, err := Resolve(*settings[name], read)
+		if err != nil {
+			// the error never includes the value, which may be a secret
+			return fmt.Errorf("unable to resolve %s: %s", name, err)
+		}
+		resolved[name] = value
+	}
+	// a service left partly resolved would be taken as resolved on the next attempt
+	for name, value := range resolved {
+		*settings[name] = value
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// schemaRequired are the keys that must be set, by the name of the type they are in
+var schemaRequired = map[string][]string{
+	"Endpoint":    {"address"},
+	"Job":         {"source", "destination", "entrypoint"},
+	"PathMapping": {"source", "destination"},
+	"Environment": {"endpoint", "path"},
+	"JobStore":    {"endpoint", "path", "scopes"},
+	"JobScope":    {"source", "destination"},
+}
+
+// schemaExtra is added to the schema of keys, by the name of the type they are in and their key
+var schemaExtra = map[string]map[string]interface{}{
+	"Job.orphans": {
+		"enum":        []string{OrphansKeep, OrphansRemove, OrphansReport},
+		"description": "what to do with secrets in the destination that are not in the source",
+	},
+	"Job.schedule": {
+		"description": "cron expression or descriptor such as @every 5m or @hourly",
+	},
+	"Job.concurrency": {
+		"enum":        []string{ConcurrencyForbid, ConcurrencyReplace, ConcurrencyAllow},
+		"description": "what the daemon does when the job is due while it is still running, defaults to forbid",
+	},
+	"Job.missed_runs": {
+		"enum":        []string{MissedRunsSkip, MissedRunsRun},
+		"description": "whether the daemon runs the job once to catch up on runs it missed, defaults to skip",
+	},
+	"Job.include": {
+		"description": "globs matching a secret path or a parent of it, * matches within a path segment",
+	},
+	"Job.exclude": {
+		"description": "globs matching a secret path or a parent of it, * matches within a path segment",
+	},
+	"JobStore.path": {
+		"description": "kv path of the jobs, each in a secret below the folder of a scope, e.g. /secret/vsync/jobs/<scope>/<job>",
+	},
+	"JobScope.source": {
+		"description": "paths the scope's jobs may sync from, globs with * matching within a path segment",
+	},
+	"JobScope.destination": {
+		"description": "paths the scope's jobs may write to, globs with * matching within a path segment",
+	},
+	"Environment.path": {
+		"description": "go template of the path of an app's secrets, e.g. /secret/apps/{{.App}}/{{.Environment}}",
+	},
+	"Endpoint.address": {
+		"description": "url of the vault or unix:///path/to/socket, settings may be env:NAME, file:/path or vault:NAME:PATH#KEY references",
+	},
+}
+
+// Schema returns the JSON Schema of the config file, which describes the yaml
+// format and, with its block names, the hcl format
+func Schema() map[string]interface{} {
+	schema := schemaOf(reflect.TypeOf(File{}), "")
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "vsync config file"
+
+	return schema
+}
+
+// schemaOf returns the schema of the values of a type
+func schemaOf(t reflect.Type, extra string) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	schema := make(map[string]interface{})
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if len(field.PkgPath) > 0 || len(name) < 1 || name == "-" {
+				continue
+			}
+			properties[name] = schemaOf(field.Type, t.Name()+"."+name)
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		if required, ok := schemaRequired[t.Name()]; ok {
+			schema["required"] = required
+		}
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = schemaOf(t.Elem(), "")
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = schemaOf(t.Elem(), "")
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int:
+		schema["type"] = "integer"
+	default:
+		schema["type"] = "string"
+	}
+
+	for k, v := range schemaExtra[extra] {
+		schema[k] = v
+	}
+
+	return schema
+}
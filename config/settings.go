@@ -0,0 +1,35 @@
+package config
+
+// where the value of a setting came from
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+	SourceDerived = "derived"
+)
+
+// masked replaces the value of a secret setting
+const masked = "********"
+
+// Setting is a single effective configuration value and where it came from
+type Setting struct {
+	Name   string `json:"name" yaml:"name"`
+	Value  string `json:"value" yaml:"value"`
+	Source string `json:"source" yaml:"source"`
+	Secret bool   `json:"-" yaml:"-"`
+}
+
+// Masked returns a copy of the settings safe to display, with secret values masked
+func Masked(settings []*Setting) []*Setting {
+	out := make([]*Setting, len(settings))
+	for i, s := range settings {
+		m := *s
+		if m.Secret && len(m.Value) > 0 {
+			m.Value = masked
+		}
+		out[i] = &m
+	}
+
+	return out
+}
@@ -0,0 +1,590 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// errorLine finds the line number in yaml and hcl parser errors
+var errorLine = regexp.MustCompile(`^(?:yaml: )?(?:line |At )(\d+)(?::\d+)?: `)
+
+// Problem is something wrong with a config file, at a line of it when known
+type Problem struct {
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// String returns the problem prefixed with its line
+func (p *Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	}
+
+	return p.Message
+}
+
+// ValidateFile checks a config file without contacting any vault, returning
+// every problem found: syntax errors, unknown keys, values of the wrong type,
+// undefined endpoints, invalid mappings, filters and schedules
+func ValidateFile(filePath string) (*File, []*Problem, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read config file: %s", err)
+	}
+
+	f := &File{Path: filePath}
+	lines := make(map[string]int)
+	var problems []*Problem
+	var parsed bool
+	if strings.EqualFold(filepath.Ext(filePath), ".hcl") {
+		problems, parsed = parseHCL(data, f, lines)
+	} else {
+		problems, parsed = parseYAML(data, f, lines)
+	}
+	// what could be decoded is checked too, to report every problem at once,
+	// except on lines that already have a problem with what was decoded there
+	if parsed {
+		decoded := problems
+		for _, problem := range f.problems(lines) {
+			if !reported(decoded, problem.Line) {
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	return f, problems, nil
+}
+
+// parseYAML decodes a yaml config file, recording the line of each key,
+// returning false when it is not yaml at all
+func parseYAML(data []byte, f *File, lines map[string]int) (problems []*Problem, parsed bool) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []*Problem{parseProblem(err)}, false
+	}
+	if len(root.Content) < 1 {
+		return nil, true
+	}
+
+	walkYAML(root.Content[0], reflect.TypeOf(f), "", lines, &problems)
+	if err := root.Content[0].Decode(f); err != nil {
+		messages := []string{err.Error()}
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			messages = typeErr.Errors
+		}
+		for _, message := range messages {
+			problem := parseProblem(errors.New(message))
+			if !reported(problems, problem.Line) {
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	return problems, true
+}
+
+// walkYAML checks the keys and kinds of a yaml node against the type it decodes into
+func walkYAML(node *yaml.Node, t reflect.Type, at string, lines map[string]int, problems *[]*Problem) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	problem := func(line int, format string, args ...interface{}) {
+		*problems = append(*problems, &Problem{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			problem(node.Line, "%s should be a mapping", describe(at))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			elem := t
+			if t.Kind() == reflect.Map {
+				elem = t.Elem()
+			} else {
+				field, ok := fieldByTag(t, "yaml", key.Value)
+				if !ok {
+					problem(key.Line, "unknown key %q in %s", key.Value, describe(at))
+					continue
+				}
+				elem = field.Type
+			}
+			lines[joinKey(at, key.Value)] = key.Line
+			walkYAML(value, elem, joinKey(at, key.Value), lines, problems)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			problem(node.Line, "%s should be a list", describe(at))
+			return
+		}
+		for i, item := range node.Content {
+			lines[indexKey(at, i)] = item.Line
+			walkYAML(item, t.Elem(), indexKey(at, i), lines, problems)
+		}
+	default:
+		if node.Kind != yaml.ScalarNode {
+			problem(node.Line, "%s should be a single value", describe(at))
+		}
+	}
+}
+
+// parseHCL decodes an hcl config file, recording the line of each key by its
+// yaml name, returning false when it is not hcl at all or could not be decoded
+func parseHCL(data []byte, f *File, lines map[string]int) (problems []*Problem, parsed bool) {
+	file, err := hcl.ParseBytes(data)
+	if err != nil {
+		return []*Problem{parseProblem(err)}, false
+	}
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return []*Problem{{Message: "the config file is not an hcl object"}}, false
+	}
+
+	walkHCL(list, reflect.TypeOf(*f), "", lines, &problems)
+	if err := hcl.DecodeObject(f, file); err != nil {
+		// hcl stops decoding at the first error
+		return append(problems, parseProblem(err)), false
+	}
+
+	return problems, true
+}
+
+// walkHCL checks the keys of an hcl object against the struct it decodes into,
+// blocks with a label are entries of a map
+func walkHCL(list *ast.ObjectList, t reflect.Type, at string, lines map[string]int, problems *[]*Problem) {
+	counts := make(map[string]int)
+	for _, item := range list.Items {
+		line := item.Pos().Line
+		key := hclKey(item.Keys[0])
+		field, ok := fieldByTag(t, "hcl", key)
+		if !ok {
+			*problems = append(*problems, &Problem{Line: line, Message: fmt.Sprintf("unknown key %q in %s", key, describe(at))})
+			continue
+		}
+		name := joinKey(at, strings.Split(field.Tag.Get("yaml"), ",")[0])
+		if _, ok := lines[name]; !ok {
+			lines[name] = line
+		}
+
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Map:
+			elem := ft.Elem()
+			if len(item.Keys) > 1 {
+				entry := joinKey(name, hclKey(item.Keys[1]))
+				lines[entry] = line
+				walkHCLValue(item.Val, elem, entry, lines, problems)
+			} else if object, ok := item.Val.(*ast.ObjectType); ok {
+				for _, sub := range object.List.Items {
+					entry := joinKey(name, hclKey(sub.Keys[0]))
+					lines[entry] = sub.Pos().Line
+					walkHCLValue(sub.Val, elem, entry, lines, problems)
+				}
+			}
+		case reflect.Slice:
+			elem := ft.Elem()
+			for elem.Kind() == reflect.Ptr {
+				elem = elem.Elem()
+			}
+			if elem.Kind() != reflect.Struct {
+				continue
+			}
+			// hcl decodes repeated blocks into a list of structs wrongly
+			list, ok := item.Val.(*ast.ListType)
+			if !ok {
+				*problems = append(*problems, &Problem{Line: line, Message: fmt.Sprintf("%s should be a list of objects, %s = [{ ... }]", describe(name), key)})
+				continue
+			}
+			for _, value := range list.List {
+				entry := indexKey(name, counts[name])
+				counts[name]++
+				lines[entry] = value.Pos().Line
+				walkHCLValue(value, elem, entry, lines, problems)
+			}
+		case reflect.Struct:
+			walkHCLValue(item.Val, ft, name, lines, problems)
+		}
+	}
+}
+
+// walkHCLValue checks a block decoded into a struct
+func walkHCLValue(node ast.Node, t reflect.Type, at string, lines map[string]int, problems *[]*Problem) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	object, ok := node.(*ast.ObjectType)
+	if !ok {
+		*problems = append(*problems, &Problem{Line: node.Pos().Line, Message: fmt.Sprintf("%s should be a block", describe(at))})
+		return
+	}
+
+	walkHCL(object.List, t, at, lines, problems)
+}
+
+// problems checks the decoded file, reporting each problem at the line of the key it is about
+func (f *File) problems(lines map[string]int) (problems []*Problem) {
+	problem := func(at string, format string, args ...interface{}) {
+		problems = append(problems, &Problem{Line: lineOf(lines, at), Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, name := range sortedKeys(f.Endpoints) {
+		if endpoint := f.Endpoints[name]; endpoint == nil || len(endpoint.Address) < 1 {
+			problem(joinKey("endpoints", name), "endpoint %s: address is required", name)
+		}
+	}
+
+	for _, name := range f.JobNames() {
+		problems = append(problems, f.jobProblems(name, f.Jobs[name], joinKey("jobs", name), lines)...)
+	}
+
+	if store := f.JobStore; store != nil {
+		if len(store.Endpoint) < 1 {
+			problem("job_store", "job store: endpoint is required")
+		} else if _, ok := f.Endpoints[store.Endpoint]; !ok {
+			problem("job_store.endpoint", "job store: endpoint %s is not defined", store.Endpoint)
+		}
+		if len(store.Path) < 1 {
+			problem("job_store", "job store: path is required")
+		}
+		if len(store.Scopes) < 1 {
+			problem("job_store", "job store: at least one scope is required, jobs outside the folder of a scope are not loaded")
+		}
+		for _, name := range store.ScopeNames() {
+			at := joinKey("job_store.scopes", name)
+			scope := store.Scopes[name]
+			if scope == nil || len(scope.Source) < 1 || len(scope.Destination) < 1 {
+				problem(at, "job store scope %s: source and destination paths are required", name)
+				continue
+			}
+			if strings.Contains(name, "/") {
+				problem(at, "job store scope %s: the name of a scope is a single folder", name)
+			}
+			for i, endpoint := range scope.Endpoints {
+				if _, ok := f.Endpoints[endpoint]; !ok {
+					problem(indexKey(joinKey(at, "endpoints"), i), "job store scope %s: endpoint %s is not defined", name, endpoint)
+				}
+			}
+			for j, patterns := range [][]string{scope.Source, scope.Destination} {
+				key := []string{"source", "destination"}[j]
+				for i, pattern := range patterns {
+					if _, err := path.Match(pattern, ""); err != nil {
+						problem(indexKey(joinKey(at, key), i), "job store scope %s: invalid %s path %q: %s", name, key, pattern, err)
+					}
+				}
+			}
+		}
+	}
+
+	for _, name := range f.EnvironmentNames() {
+		at := joinKey("environments", name)
+		environment := f.Environments[name]
+		if environment == nil {
+			problem(at, "environment %s: endpoint and path are required", name)
+			continue
+		}
+		if len(environment.Endpoint) < 1 {
+			problem(at, "environment %s: endpoint is required", name)
+		} else if _, ok := f.Endpoints[environment.Endpoint]; !ok {
+			problem(joinKey(at, "endpoint"), "environment %s: endpoint %s is not defined", name, environment.Endpoint)
+		}
+		if len(environment.Path) < 1 {
+			problem(at, "environment %s: path is required", name)
+		} else if _, err := environment.AppPath("app", name); err != nil {
+			problem(joinKey(at, "path"), "environment %s: invalid path template: %s", name, err)
+		}
+	}
+
+	return problems
+}
+
+// jobProblems checks a job, its endpoints must be defined in the file
+func (f *File) jobProblems(name string, job *Job, at string, lines map[string]int) (problems []*Problem) {
+	problem := func(at string, format string, args ...interface{}) {
+		problems = append(problems, &Problem{Line: lineOf(lines, at), Message: fmt.Sprintf(format, args...)})
+	}
+
+	if job == nil {
+		problem(at, "job %s: source, destination and entrypoint are required", name)
+		return problems
+	}
+
+	for i, endpoint := range []string{job.Source, job.Destination} {
+		key := []string{"source", "destination"}[i]
+		if len(endpoint) < 1 {
+			problem(at, "job %s: %s is required", name, key)
+		} else if _, ok := f.Endpoints[endpoint]; !ok {
+			problem(joinKey(at, key), "job %s: %s endpoint %s is not defined", name, key, endpoint)
+		}
+	}
+	if len(job.Entrypoint) < 1 {
+		problem(at, "job %s: entrypoint is required", name)
+	} else if job.Source == job.Destination && len(job.Source) > 0 {
+		destination := job.DestinationEntrypoint
+		if len(destination) < 1 {
+			destination = job.Entrypoint
+		}
+		if UnderPath(destination, job.Entrypoint) || UnderPath(job.Entrypoint, destination) {
+			problem(joinKey(at, "entrypoint"), "job %s: syncs %s into itself in endpoint %s", name, CleanPath(job.Entrypoint), job.Source)
+		}
+	}
+
+	switch job.Orphans {
+	case "", OrphansKeep, OrphansRemove, OrphansReport:
+	default:
+		problem(joinKey(at, "orphans"), "job %s: unknown orphans policy %q, expected keep|remove|report", name, job.Orphans)
+	}
+
+	if len(job.Schedule) > 0 {
+		if _, err := cron.ParseStandard(job.Schedule); err != nil {
+			problem(joinKey(at, "schedule"), "job %s: invalid schedule %q: %s", name, job.Schedule, err)
+		}
+	}
+
+	switch job.Concurrency {
+	case "", ConcurrencyForbid, ConcurrencyReplace, ConcurrencyAllow:
+	default:
+		problem(joinKey(at, "concurrency"), "job %s: unknown concurrency policy %q, expected forbid|replace|allow", name, job.Concurrency)
+	}
+
+	switch job.MissedRuns {
+	case "", MissedRunsSkip, MissedRunsRun:
+	default:
+		problem(joinKey(at, "missed_runs"), "job %s: unknown missed runs policy %q, expected skip|run", name, job.MissedRuns)
+	}
+
+	problems = append(problems, job.filterProblems(name, at, lines)...)
+	problems = append(problems, job.mappingProblems(name, at, lines)...)
+
+	for i, m := range job.NamespaceMappings {
+		if m == nil {
+			problem(indexKey(joinKey(at, "namespace_mappings"), i), "job %s: namespace mappings need a source and destination namespace", name)
+		}
+	}
+
+	return problems
+}
+
+// filterProblems checks the include and exclude patterns of a job are valid and
+// that no include is entirely excluded
+func (job *Job) filterProblems(name, at string, lines map[string]int) (problems []*Problem) {
+	problem := func(at string, format string, args ...interface{}) {
+		problems = append(problems, &Problem{Line: lineOf(lines, at), Message: fmt.Sprintf(format, args...)})
+	}
+
+	for j, patterns := range [][]string{job.Include, job.Exclude} {
+		key := []string{"include", "exclude"}[j]
+		for i, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				problem(indexKey(joinKey(at, key), i), "job %s: invalid %s pattern %q: %s", name, key, pattern, err)
+			}
+		}
+	}
+
+	for i, include := range job.Include {
+		for _, exclude := range job.Exclude {
+			switch {
+			case CleanPath(include) == CleanPath(exclude):
+				problem(indexKey(joinKey(at, "include"), i), "job %s: %s is both included and excluded", name, include)
+			case !strings.ContainsAny(include, "*?[") && MatchPath(exclude, include):
+				problem(indexKey(joinKey(at, "include"), i), "job %s: include %s is entirely excluded by %s", name, include, exclude)
+			}
+		}
+	}
+
+	return problems
+}
+
+// mappingProblems checks the path mappings of a job are paths below its
+// entrypoint, map each path once and do not lead back to themselves
+func (job *Job) mappingProblems(name, at string, lines map[string]int) (problems []*Problem) {
+	problem := func(at string, format string, args ...interface{}) {
+		problems = append(problems, &Problem{Line: lineOf(lines, at), Message: fmt.Sprintf(format, args...)})
+	}
+
+	var valid []*PathMapping
+	sources := make(map[string]bool)
+	for i, m := range job.Mappings {
+		mappingAt := indexKey(joinKey(at, "mappings"), i)
+		switch {
+		case m == nil || len(m.Source) < 1 || len(m.Destination) < 1:
+			problem(mappingAt, "job %s: mappings need a source and destination path", name)
+			continue
+		case strings.ContainsAny(m.Source+m.Destination, "*?["):
+			problem(mappingAt, "job %s: mapping %s to %s: mappings are paths, not patterns", name, m.Source, m.Destination)
+			continue
+		case len(job.Entrypoint) > 0 && !UnderPath(m.Source, job.Entrypoint):
+			problem(mappingAt, "job %s: mapping source %s is not below the entrypoint %s, it never applies", name, m.Source, job.Entrypoint)
+		case sources[CleanPath(m.Source)]:
+			problem(mappingAt, "job %s: %s is mapped more than once", name, m.Source)
+		}
+		sources[CleanPath(m.Source)] = true
+		valid = append(valid, m)
+	}
+
+	// a mapping leads to another when it writes below the other's source
+	reported := make(map[string]bool)
+	for start := range valid {
+		cycle := mappingCycle(valid, start, []int{start})
+		if cycle == nil {
+			continue
+		}
+		var chain, members []string
+		for _, i := range cycle {
+			chain = append(chain, CleanPath(valid[i].Source))
+			members = append(members, CleanPath(valid[i].Source))
+		}
+		sort.Strings(members)
+		if reported[strings.Join(members, " ")] {
+			continue
+		}
+		reported[strings.Join(members, " ")] = true
+		chain = append(chain, CleanPath(valid[start].Source))
+		problem(indexKey(joinKey(at, "mappings"), start), "job %s: mapping cycle %s", name, strings.Join(chain, " -> "))
+	}
+
+	return problems
+}
+
+// mappingCycle follows the mappings from the last one visited, returning the
+// mappings visited when they lead back to the first
+func mappingCycle(mappings []*PathMapping, start int, visited []int) []int {
+	last := mappings[visited[len(visited)-1]]
+	for next, m := range mappings {
+		if next == visited[len(visited)-1] || !UnderPath(last.Destination, m.Source) {
+			continue
+		}
+		if next == start {
+			return visited
+		}
+		seen := false
+		for _, i := range visited {
+			seen = seen || i == next
+		}
+		if seen {
+			continue
+		}
+		if cycle := mappingCycle(mappings, start, append(visited, next)); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// parseProblem turns a parser error into a problem at the line it mentions
+func parseProblem(err error) *Problem {
+	problem := &Problem{Message: err.Error()}
+	if match := errorLine.FindStringSubmatch(err.Error()); match != nil {
+		problem.Line, _ = strconv.Atoi(match[1])
+		problem.Message = strings.TrimPrefix(problem.Message, match[0])
+	}
+
+	return problem
+}
+
+// reported returns true when there is already a problem at the line
+func reported(problems []*Problem, line int) bool {
+	for _, problem := range problems {
+		if line > 0 && problem.Line == line {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fieldByTag returns the field of a struct with the key as its tag
+func fieldByTag(t reflect.Type, tag, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if len(name) > 0 && name != "-" && name == key {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// hclKey returns the name of an hcl key, unquoted
+func hclKey(key *ast.ObjectKey) string {
+	if value, ok := key.Token.Value().(string); ok {
+		return value
+	}
+
+	return key.Token.Text
+}
+
+// lineOf returns the line of a key, or of the closest parent key that is in the file
+func lineOf(lines map[string]int, at string) int {
+	for len(at) > 0 {
+		if line, ok := lines[at]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(at, ".[")
+		if i < 0 {
+			break
+		}
+		at = at[:i]
+	}
+
+	return 0
+}
+
+// joinKey joins the keys of a path through the file
+func joinKey(at, key string) string {
+	if len(at) < 1 {
+		return key
+	}
+
+	return at + "." + key
+}
+
+// indexKey returns the path of an item of a list
+func indexKey(at string, i int) string {
+	return fmt.Sprintf("%s[%d]", at, i)
+}
+
+// describe names a path through the file in problems
+func describe(at string) string {
+	if len(at) < 1 {
+		return "the config file"
+	}
+
+	return at
+}
+
+// sortedKeys returns the keys of the endpoints, sorted
+func sortedKeys(endpoints map[string]*Endpoint) []string {
+	keys := make([]string, 0, len(endpoints))
+	for key := range endpoints {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
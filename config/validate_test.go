@@ -0,0 +1,148 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// messages returns the messages of the problems
+func messages(problems []*Problem) []string {
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.Message)
+	}
+
+	return messages
+}
+
+func TestMappingProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings []*PathMapping
+		want     []string
+	}{
+		{name: "valid", mappings: []*PathMapping{
+			{Source: "/secret/payments/legacy", Destination: "/secret/payments/v1"},
+			{Source: "/secret/payments/db", Destination: "/kv/db"},
+		}},
+		{name: "missing destination", mappings: []*PathMapping{{Source: "/secret/payments/a"}}, want: []string{
+			"job payments: mappings need a source and destination path",
+		}},
+		{name: "pattern", mappings: []*PathMapping{{Source: "/secret/payments/*", Destination: "/kv"}}, want: []string{
+			"job payments: mapping /secret/payments/* to /kv: mappings are paths, not patterns",
+		}},
+		{name: "outside the entrypoint", mappings: []*PathMapping{{Source: "/secret/other", Destination: "/kv/other"}}, want: []string{
+			"job payments: mapping source /secret/other is not below the entrypoint /secret/payments, it never applies",
+		}},
+		{name: "mapped twice", mappings: []*PathMapping{
+			{Source: "/secret/payments/a", Destination: "/kv/a"},
+			{Source: "/secret/payments/a/", Destination: "/kv/b"},
+		}, want: []string{
+			"job payments: /secret/payments/a/ is mapped more than once",
+		}},
+		{name: "cycle", mappings: []*PathMapping{
+			{Source: "/secret/payments/a", Destination: "/secret/payments/b"},
+			{Source: "/secret/payments/b", Destination: "/secret/payments/a"},
+		}, want: []string{
+			"job payments: mapping cycle /secret/payments/a -> /secret/payments/b -> /secret/payments/a",
+		}},
+		{name: "cycle below", mappings: []*PathMapping{
+			{Source: "/secret/payments/a", Destination: "/secret/payments/b/x"},
+			{Source: "/secret/payments/b", Destination: "/secret/payments/c"},
+			{Source: "/secret/payments/c", Destination: "/secret/payments/a/y"},
+			{Source: "/secret/payments/d", Destination: "/secret/payments/a"},
+		}, want: []string{
+			"job payments: mapping cycle /secret/payments/a -> /secret/payments/b -> /secret/payments/c -> /secret/payments/a",
+		}},
+		{name: "chain", mappings: []*PathMapping{
+			{Source: "/secret/payments/a", Destination: "/secret/payments/b"},
+			{Source: "/secret/payments/b", Destination: "/secret/payments/c"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := &Job{Entrypoint: "/secret/payments", Mappings: test.mappings}
+			if got := messages(job.mappingProblems("payments", "", nil)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("mappingProblems() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMappingCycle(t *testing.T) {
+	mappings := []*PathMapping{
+		{Source: "/a", Destination: "/b"},
+		{Source: "/b", Destination: "/c/x"},
+		{Source: "/c", Destination: "/a"},
+		{Source: "/d", Destination: "/a"},
+		{Source: "/e", Destination: "/e/f"},
+	}
+
+	tests := []struct {
+		start int
+		want  []int
+	}{
+		{start: 0, want: []int{0, 1, 2}},
+		{start: 2, want: []int{2, 0, 1}},
+		// leads into a cycle without being part of it
+		{start: 3, want: nil},
+		// a mapping below itself is sync'd once, not again
+		{start: 4, want: nil},
+	}
+
+	for _, test := range tests {
+		if got := mappingCycle(mappings, test.start, []int{test.start}); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("mappingCycle(%v) = %v, want %v", test.start, got, test.want)
+		}
+	}
+}
+
+func TestValidateFileLines(t *testing.T) {
+	f, err := ioutil.TempFile("", "vsync-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`endpoints:
+  staging:
+    address: https://vault.staging.example.com:8200
+jobs:
+  payments:
+    source: staging
+    destination: prod
+    entrypoint: /secret/payments
+    mappings:
+      - source: /secret/payments/a
+        destination: /secret/payments/b
+      - source: /secret/payments/b
+        destination: /secret/payments/a
+    schedule: every hour
+`)
+	f.Close()
+
+	_, problems, err := ValidateFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, problem := range problems {
+		got = append(got, problem.String())
+	}
+	want := []string{
+		"line 7: job payments: destination endpoint prod is not defined",
+		"line 14: job payments: invalid schedule",
+		"line 10: job payments: mapping cycle /secret/payments/a -> /secret/payments/b -> /secret/payments/a",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ValidateFile() problems %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("problem %q, want %q", got[i], want[i])
+		}
+	}
+}
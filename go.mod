@@ -3,9 +3,12 @@ module github.com/flaccid/vsync
 go 1.13
 
 require (
-	github.com/davecgh/go-spew v1.1.1
+	github.com/hashicorp/hcl v1.0.0
 	github.com/hashicorp/vault/api v1.0.4
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.4.2
 	github.com/tidwall/pretty v1.0.0
 	github.com/urfave/cli v1.22.2
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/flaccid/vsync/vault"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// when to notify
+	OnChanges  = "changes"
+	OnFailures = "failures"
+	OnBoth     = "both"
+
+	// body presets
+	PresetJSON  = "json"
+	PresetSlack = "slack"
+)
+
+// Webhook is an endpoint notified with the report of a run
+type Webhook struct {
+	URL      string `json:"url"`
+	Preset   string `json:"preset,omitempty"`
+	Template string `json:"template,omitempty"`
+	On       string `json:"on,omitempty"`
+	Retries  int    `json:"retries,omitempty"`
+}
+
+var httpClient = &http.Client{Timeout: time.Duration(15) * time.Second}
+
+// Send posts the report to every webhook that wants to know about it
+func Send(webhooks []*Webhook, report *vault.Report) error {
+	var failed []string
+	for _, webhook := range webhooks {
+		if !webhook.wants(report) {
+			log.Debugf("webhook %s not notified, nothing it is interested in", webhook.URL)
+			continue
+		}
+		if err := webhook.Send(report); err != nil {
+			log.Errorf("failed to notify webhook: %s", err)
+			failed = append(failed, webhook.URL)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to notify %v webhook(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// Validate checks the webhook settings are usable
+func (w *Webhook) Validate() error {
+	if len(w.URL) < 1 {
+		return errors.New("webhook url is required")
+	}
+	switch w.On {
+	case "", OnChanges, OnFailures, OnBoth:
+	default:
+		return fmt.Errorf("webhook %s: unknown notify on %q, expected changes|failures|both", w.URL, w.On)
+	}
+	switch w.Preset {
+	case "", PresetJSON, PresetSlack:
+	default:
+		return fmt.Errorf("webhook %s: unknown preset %q, expected json|slack", w.URL, w.Preset)
+	}
+	if len(w.Template) > 0 {
+		if _, err := w.template(); err != nil {
+			return fmt.Errorf("webhook %s: %s", w.URL, err)
+		}
+	}
+
+	return nil
+}
+
+// Send posts the report to the webhook, retrying on failure
+func (w *Webhook) Send(report *vault.Report) error {
+	body, err := w.Body(report)
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = w.post(body)
+		if err == nil {
+			log.Infof("notified webhook %s", w.URL)
+			return nil
+		}
+		if attempt >= w.Retries {
+			return err
+		}
+		log.Warnf("webhook %s failed, retrying in %s: %s", w.URL, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Body renders the json body posted for the report
+func (w *Webhook) Body(report *vault.Report) ([]byte, error) {
+	if len(w.Template) > 0 {
+		t, err := w.template()
+		if err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, report); err != nil {
+			return nil, fmt.Errorf("error rendering webhook template: %s", err)
+		}
+		if !json.Valid(b.Bytes()) {
+			return nil, errors.New("webhook template did not render valid json")
+		}
+		return b.Bytes(), nil
+	}
+
+	if w.Preset == PresetSlack {
+		return json.Marshal(map[string]string{"text": Summary(report)})
+	}
+
+	return json.Marshal(report)
+}
+
+// Summary returns a human readable summary of the report
+func Summary(report *vault.Report) string {
+	var b strings.Builder
+
+	name := "vsync"
+	if len(report.Job) > 0 {
+		name = fmt.Sprintf("vsync job %s", report.Job)
+	}
+	if report.DryRun {
+		name += " (dry run)"
+	}
+	fmt.Fprintf(&b, "%s: %v changed, %v removed, %v failed", name, len(report.Changed), len(report.Removed), len(report.Failed))
+	for _, p := range report.Changed {
+		fmt.Fprintf(&b, "\nchanged: %s", p)
+	}
+	for _, p := range report.Removed {
+		fmt.Fprintf(&b, "\nremoved: %s", p)
+	}
+	for _, f := range report.Failed {
+		fmt.Fprintf(&b, "\nfailed: %s", f.Path)
+	}
+
+	return b.String()
+}
+
+// wants returns true when the report has what the webhook wants to be notified of
+func (w *Webhook) wants(report *vault.Report) bool {
+	switch w.On {
+	case OnChanges:
+		return report.HasChanges()
+	case OnFailures:
+		return report.HasFailures()
+	default:
+		return report.HasChanges() || report.HasFailures()
+	}
+}
+
+// template parses the webhook's template file
+func (w *Webhook) template() (*template.Template, error) {
+	text, err := ioutil.ReadFile(w.Template)
+	if err != nil {
+		return nil, fmt.Errorf("error reading webhook template: %s", err)
+	}
+
+	return template.New(w.Template).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"summary": Summary,
+	}).Parse(string(text))
+}
+
+// post makes a single attempt at posting the body
+func (w *Webhook) post(body []byte) error {
+	resp, err := httpClient.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %s", w.URL, resp.Status)
+	}
+
+	return nil
+}
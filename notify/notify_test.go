@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flaccid/vsync/vault"
+)
+
+// testReport returns a report of a run with a change, a removal and a failure
+func testReport() *vault.Report {
+	report := vault.NewReport("payments", false)
+	report.Change("/secret/payments/db")
+	report.Remove("/secret/payments/old")
+	report.Fail("/secret/payments/api", errors.New("permission denied"))
+
+	return report
+}
+
+// templateFile writes a webhook template, returning its name
+func templateFile(t *testing.T, text string) string {
+	f, err := ioutil.TempFile("", "vsync-webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestBody(t *testing.T) {
+	summaryTemplate := templateFile(t, `{"summary": {{ summary . | json }}, "failed": {{ len .Failed }}}`)
+	defer os.Remove(summaryTemplate)
+	invalidTemplate := templateFile(t, `{"job": {{ .Job }}}`)
+	defer os.Remove(invalidTemplate)
+
+	summary := "vsync job payments: 1 changed, 1 removed, 1 failed\nchanged: /secret/payments/db\nremoved: /secret/payments/old\nfailed: /secret/payments/api"
+	tests := []struct {
+		name    string
+		webhook *Webhook
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{name: "slack", webhook: &Webhook{Preset: PresetSlack}, want: map[string]interface{}{"text": summary}},
+		{name: "template", webhook: &Webhook{Template: summaryTemplate}, want: map[string]interface{}{"summary": summary, "failed": float64(1)}},
+		{name: "template over the preset", webhook: &Webhook{Preset: PresetSlack, Template: summaryTemplate}, want: map[string]interface{}{"summary": summary, "failed": float64(1)}},
+		{name: "template not json", webhook: &Webhook{Template: invalidTemplate}, wantErr: "webhook template did not render valid json"},
+		{name: "missing template", webhook: &Webhook{Template: "/nonexistent/template"}, wantErr: "error reading webhook template"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := test.webhook.Body(testReport())
+			if len(test.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("Body() error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range test.want {
+				if got[key] != want {
+					t.Errorf("%s = %#v, want %#v", key, got[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestBodyJSON(t *testing.T) {
+	body, err := (&Webhook{Preset: PresetJSON}).Body(testReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got vault.Report
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Job != "payments" || len(got.Changed) != 1 || len(got.Removed) != 1 || len(got.Failed) != 1 || got.Failed[0].Error != "permission denied" {
+		t.Errorf("body %s, want the whole report", body)
+	}
+}
+
+// testEndpoint is a webhook endpoint answering with the statuses in turn, then 200
+type testEndpoint struct {
+	mutex    sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (e *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.bodies = append(e.bodies, string(body))
+	if len(e.statuses) > 0 {
+		w.WriteHeader(e.statuses[0])
+		e.statuses = e.statuses[1:]
+	}
+}
+
+func TestSend(t *testing.T) {
+	changed := vault.NewReport("payments", false)
+	changed.Change("/secret/payments/db")
+
+	tests := []struct {
+		name       string
+		on         string
+		report     *vault.Report
+		statuses   []int
+		retries    int
+		wantPosts  int
+		wantFailed bool
+	}{
+		{name: "changes", on: OnChanges, report: changed, wantPosts: 1},
+		{name: "no changes", on: OnChanges, report: vault.NewReport("payments", false), wantPosts: 0},
+		{name: "failures only", on: OnFailures, report: changed, wantPosts: 0},
+		{name: "failures", on: OnFailures, report: testReport(), wantPosts: 1},
+		{name: "both", on: OnBoth, report: testReport(), wantPosts: 1},
+		{name: "failing endpoint", report: changed, statuses: []int{http.StatusBadGateway}, wantPosts: 1, wantFailed: true},
+		{name: "retried", report: changed, statuses: []int{http.StatusServiceUnavailable}, retries: 1, wantPosts: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := &testEndpoint{statuses: test.statuses}
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+
+			webhook := &Webhook{URL: server.URL, On: test.on, Retries: test.retries}
+			err := Send([]*Webhook{webhook}, test.report)
+			if test.wantFailed {
+				if err == nil || !strings.Contains(err.Error(), "failed to notify 1 webhook(s): "+server.URL) {
+					t.Errorf("Send() = %v, want the webhook failed", err)
+				}
+			} else if err != nil {
+				t.Errorf("Send() = %s, want nil", err)
+			}
+			if len(endpoint.bodies) != test.wantPosts {
+				t.Errorf("posted %v time(s), want %v", len(endpoint.bodies), test.wantPosts)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		webhook *Webhook
+		wantErr string
+	}{
+		{webhook: &Webhook{URL: "https://hooks.example.com", On: OnChanges, Preset: PresetSlack}},
+		{webhook: &Webhook{}, wantErr: "webhook url is required"},
+		{webhook: &Webhook{URL: "https://hooks.example.com", On: "always"}, wantErr: `unknown notify on "always"`},
+		{webhook: &Webhook{URL: "https://hooks.example.com", Preset: "teams"}, wantErr: `unknown preset "teams"`},
+		{webhook: &Webhook{URL: "https://hooks.example.com", Template: "/nonexistent/template"}, wantErr: "error reading webhook template"},
+	}
+
+	for _, test := range tests {
+		err := test.webhook.Validate()
+		if len(test.wantErr) < 1 {
+			if err != nil {
+				t.Errorf("Validate() = %s, want nil", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("Validate() = %v, want %q", err, test.wantErr)
+		}
+	}
+}
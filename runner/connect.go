@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/flaccid/vsync/vault"
+	log "github.com/sirupsen/logrus"
+)
+
+// Connect logs in to an endpoint of a config file the first time a job
+// uses it and keeps its token valid for the rest of the run
+func (r *Runner) Connect(f *config.File, name string) error {
+	r.connectMutex.Lock()
+	defer r.connectMutex.Unlock()
+
+	return r.connect(f, name, make(map[string]bool))
+}
+
+// Context returns the vault service of a context with its references resolved
+func (r *Runner) Context(name string) (*config.VaultService, error) {
+	service, err := r.Contexts.Service(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.connectMutex.Lock()
+	defer r.connectMutex.Unlock()
+	if err := r.resolveService(r.File, "context "+name, service, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+
+	return service, nil
+}
+
+// connect connects an endpoint, resolving is the endpoints and contexts whose
+// references are being resolved by the connection, connections must be locked
+func (r *Runner) connect(f *config.File, name string, resolving map[string]bool) error {
+	service, err := f.Service(name)
+	if err != nil {
+		return err
+	}
+	if service.Client != nil {
+		return nil
+	}
+	if err := r.resolveService(f, "endpoint "+name, service, resolving); err != nil {
+		return err
+	}
+
+	service.Client, err = vault.Connect(service)
+	if err != nil {
+		return fmt.Errorf("error creating client for endpoint %s: %s", name, err)
+	}
+	watcher := vault.WatchToken(name, service)
+	r.filesMutex.Lock()
+	r.watchers[service] = watcher
+	r.filesMutex.Unlock()
+
+	return nil
+}
+
+// resolveService replaces the references in the settings of a config file
+// endpoint or context with the values they refer to, connections must be locked
+func (r *Runner) resolveService(f *config.File, name string, service *config.VaultService, resolving map[string]bool) error {
+	if resolving[name] {
+		return fmt.Errorf("%s refers to itself through vault references", name)
+	}
+	resolving[name] = true
+	defer delete(resolving, name)
+
+	if err := config.ResolveService(service, r.referenceReader(f, resolving)); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	return nil
+}
+
+// referenceReader reads a key of a secret for a vault: reference, from the
+// endpoint of the config file with the name or otherwise the context
+func (r *Runner) referenceReader(f *config.File, resolving map[string]bool) config.SecretReader {
+	return func(name, secretPath, key string) (string, error) {
+		var service *config.VaultService
+		if f != nil && f.Endpoints[name] != nil {
+			if err := r.connect(f, name, resolving); err != nil {
+				return "", err
+			}
+			service, _ = f.Service(name)
+		} else if service = r.referenced[name]; service == nil {
+			if r.Contexts == nil {
+				return "", fmt.Errorf("there is no endpoint or context %s", name)
+			}
+			var err error
+			service, err = r.Contexts.Service(name)
+			if err != nil {
+				return "", err
+			}
+			if err := r.resolveService(f, "context "+name, service, resolving); err != nil {
+				return "", err
+			}
+			service.Client, err = vault.Connect(service)
+			if err != nil {
+				return "", fmt.Errorf("error creating client for context %s: %s", name, err)
+			}
+			r.referenced[name] = service
+		}
+
+		log.Debugf("read %s#%s from %s for a vault reference", secretPath, key, name)
+		return vault.ReadSecretKey(service, secretPath, key)
+	}
+}
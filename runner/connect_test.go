@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flaccid/vsync/config"
+)
+
+func TestConnectReferences(t *testing.T) {
+	store := newStubVault(map[string]string{
+		"GET /v1/secret/data/tokens": `{"data": {"data": {"staging": "staging-token", "prod": "prod-token"}, "metadata": {"version": 1}}}`,
+	}, nil)
+	defer store.Close()
+	f := &config.File{Path: "vsync.yaml", Endpoints: map[string]*config.Endpoint{
+		"store":   {Address: store.URL, Auth: &config.EndpointAuth{Token: "token"}},
+		"staging": {Address: store.URL, Auth: &config.EndpointAuth{Token: "vault:store:/secret/tokens#staging"}},
+		"prod":    {Address: store.URL, Auth: &config.EndpointAuth{Token: "vault:store:/secret/tokens#prod"}},
+	}}
+	r := New(f, nil)
+
+	// jobs connecting at once read their references without seeing each other's
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, endpoint := range []string{"staging", "prod"} {
+			wg.Add(1)
+			go func(endpoint string) {
+				defer wg.Done()
+				if err := r.Connect(f, endpoint); err != nil {
+					t.Errorf("Connect(%s) = %s", endpoint, err)
+				}
+			}(endpoint)
+		}
+	}
+	wg.Wait()
+
+	for _, endpoint := range []string{"staging", "prod"} {
+		service, _ := f.Service(endpoint)
+		if service.Client == nil || service.Client.Token() != endpoint+"-token" {
+			t.Errorf("endpoint %s is not connected with the token it refers to", endpoint)
+		}
+	}
+}
+
+func TestConnectCycle(t *testing.T) {
+	store := newStubVault(nil, nil)
+	defer store.Close()
+	f := &config.File{Path: "vsync.yaml", Endpoints: map[string]*config.Endpoint{
+		"a": {Address: store.URL, Auth: &config.EndpointAuth{Token: "vault:b:/secret/tokens#a"}},
+		"b": {Address: store.URL, Auth: &config.EndpointAuth{Token: "vault:a:/secret/tokens#b"}},
+		"c": {Address: store.URL, Auth: &config.EndpointAuth{Token: "vault:c:/secret/tokens#c"}},
+	}}
+	r := New(f, nil)
+
+	for _, endpoint := range []string{"a", "c"} {
+		err := r.Connect(f, endpoint)
+		if err == nil || !strings.Contains(err.Error(), "endpoint "+endpoint+" refers to itself") {
+			t.Errorf("Connect(%s) = %v, want the cycle", endpoint, err)
+		}
+		if service, _ := f.Service(endpoint); service.Client != nil {
+			t.Errorf("endpoint %s was connected", endpoint)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/flaccid/vsync/notify"
+	"github.com/flaccid/vsync/scheduler"
+	"github.com/flaccid/vsync/vault"
+	log "github.com/sirupsen/logrus"
+)
+
+// RunDaemon runs the scheduled jobs of the config file until it receives SIGINT
+// or SIGTERM, the endpoints are logged in to once and shared by every run of every
+// job, the config file is reloaded when it or the jobs in its job store change, or on SIGHUP
+func (r *Runner) RunDaemon(signals <-chan os.Signal) error {
+	if err := r.LoadJobStore(r.File); err != nil {
+		return err
+	}
+	names := r.scheduledJobs(r.File)
+	if len(names) < 1 {
+		return fmt.Errorf("there are no jobs with a schedule in %s", r.File.Path)
+	}
+	if err := r.connectJobs(r.File, names); err != nil {
+		return err
+	}
+	vault.CacheMounts(r.MountCacheTTL)
+	r.filesMutex.Lock()
+	r.daemonFile = r.File
+	r.filesMutex.Unlock()
+
+	s, err := scheduler.New(r.File, names, r.StatusFile, func(f *config.File, name string, stop <-chan struct{}) (*vault.Report, error) {
+		r.startedRun(f)
+		defer r.finishedRun(f)
+
+		report, err := r.RunJob(f, name, stop)
+		if err != nil {
+			return report, err
+		}
+		if err := notify.Send(r.Webhooks, report); err != nil {
+			log.Error(err)
+		}
+		return report, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	log.Infof("scheduled %v job(s): %s", len(names), strings.Join(names, ", "))
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+
+	changed := make(chan struct{}, 1)
+	if r.ReloadInterval > 0 {
+		go r.watchConfig(r.File.Path, r.ReloadInterval, changed, stop)
+	}
+
+	current := r.File
+	for {
+		reason := "the config file changed"
+		if current.JobStore != nil {
+			reason = "the config file or its job store changed"
+		}
+		select {
+		case <-changed:
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Infof("received %s, stopping the runs in progress", sig)
+				close(stop)
+				<-done
+				log.Info("all runs stopped")
+				return nil
+			}
+			reason = "received " + sig.String()
+		}
+
+		log.Infof("%s, reloading %s", reason, current.Path)
+		f, err := r.reloadConfig(s, current)
+		if err != nil {
+			log.Errorf("unable to reload %s, keeping the last good config: %s", current.Path, err)
+			continue
+		}
+		current = f
+	}
+}
+
+// scheduledJobs returns the jobs the daemon runs, those given or every job with a schedule
+func (r *Runner) scheduledJobs(f *config.File) []string {
+	if len(r.Jobs) > 0 {
+		return r.Jobs
+	}
+
+	var names []string
+	for _, name := range f.JobNames() {
+		if len(f.Jobs[name].Schedule) > 0 {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// connectJobs connects the endpoints of the jobs up front so their runs only share the clients
+func (r *Runner) connectJobs(f *config.File, names []string) error {
+	for _, name := range names {
+		job, ok := f.Jobs[name]
+		if !ok {
+			return fmt.Errorf("job %s is not defined in %s", name, f.Path)
+		}
+		for _, endpoint := range []string{job.Source, job.Destination} {
+			if err := r.Connect(f, endpoint); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// reloadConfig loads the config file again and schedules its jobs in place of
+// the current ones, unchanged endpoints keep their clients, nothing changes on an error
+func (r *Runner) reloadConfig(s *scheduler.Scheduler, current *config.File) (*config.File, error) {
+	f, err := config.LoadFile(current.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Keep(current)
+	if err := r.LoadJobStore(f); err != nil {
+		r.retireFile(f, current)
+		return nil, err
+	}
+	names := r.scheduledJobs(f)
+	if len(names) < 1 {
+		r.retireFile(f, current)
+		return nil, fmt.Errorf("there are no jobs with a schedule in %s", f.Path)
+	}
+	if err := r.connectJobs(f, names); err != nil {
+		r.retireFile(f, current)
+		return nil, err
+	}
+	if err := s.Reload(f, names); err != nil {
+		r.retireFile(f, current)
+		return nil, err
+	}
+	r.retireFile(current, f)
+	log.Infof("reloaded %s, scheduled %v job(s): %s", f.Path, len(names), strings.Join(names, ", "))
+
+	return f, nil
+}
+
+// watchConfig signals changed when the contents of the config file or the jobs in its
+// job store change, checking every interval, which also sees a config map mounted in
+// kubernetes being updated
+func (r *Runner) watchConfig(path string, interval time.Duration, changed chan<- struct{}, stop <-chan struct{}) {
+	last, _ := r.configState(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// the file may be missing for a moment while it is replaced
+		data, err := r.configState(path)
+		if err != nil || bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// configState returns the contents of the config file followed by the jobs in the
+// job store of the config file the daemon runs
+func (r *Runner) configState(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r.filesMutex.Lock()
+	f := r.daemonFile
+	r.filesMutex.Unlock()
+	if f == nil || f.JobStore == nil {
+		return data, nil
+	}
+	service, err := f.Service(f.JobStore.Endpoint)
+	if err != nil || service.Client == nil {
+		return data, nil
+	}
+	documents, err := vault.ReadJobStore(service, f.JobStore.Path)
+	if err != nil {
+		log.Warnf("unable to check the job store for changes: %s", err)
+		return nil, err
+	}
+	stored, err := json.Marshal(documents)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, stored...), nil
+}
+
+// startedRun counts a run in progress with a config file
+func (r *Runner) startedRun(f *config.File) {
+	r.filesMutex.Lock()
+	defer r.filesMutex.Unlock()
+
+	r.runningFiles[f]++
+}
+
+// finishedRun counts a run with a config file finishing
+func (r *Runner) finishedRun(f *config.File) {
+	r.filesMutex.Lock()
+	defer r.filesMutex.Unlock()
+
+	if r.runningFiles[f]--; r.runningFiles[f] < 1 {
+		delete(r.runningFiles, f)
+	}
+	r.releaseFiles()
+}
+
+// retireFile marks a config file as replaced by the current one
+func (r *Runner) retireFile(f, current *config.File) {
+	r.filesMutex.Lock()
+	defer r.filesMutex.Unlock()
+
+	r.daemonFile = current
+	r.retiredFiles[f] = true
+	r.releaseFiles()
+}
+
+// releaseFiles stops keeping the tokens of the endpoints of replaced config files
+// valid once their runs have finished, unless the current config file or a run in
+// progress still uses them, files must be locked
+func (r *Runner) releaseFiles() {
+	inUse := make(map[*config.VaultService]bool)
+	for _, service := range r.daemonFile.Connected() {
+		inUse[service] = true
+	}
+	for f := range r.runningFiles {
+		for _, service := range f.Connected() {
+			inUse[service] = true
+		}
+	}
+
+	for f := range r.retiredFiles {
+		if r.runningFiles[f] > 0 {
+			continue
+		}
+		for _, service := range f.Connected() {
+			if watcher, ok := r.watchers[service]; ok && !inUse[service] {
+				watcher.Stop()
+				delete(r.watchers, service)
+			}
+		}
+		delete(r.retiredFiles, f)
+	}
+}
@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+)
+
+// writeConfig writes a config file of a job from the source to the destination
+// vault and the extra jobs, on the schedule
+func writeConfig(t *testing.T, path string, source, destination *stubVault, schedule string, extra string) {
+	data := `endpoints:
+  staging:
+    address: ` + source.URL + `
+    auth:
+      token: token
+  prod:
+    address: ` + destination.URL + `
+    auth:
+      token: token
+jobs:
+  apps:
+    source: staging
+    destination: prod
+    entrypoint: /secret/apps
+    schedule: "` + schedule + `"
+` + extra
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor waits for the condition to hold
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRunDaemon(t *testing.T) {
+	source := newStubVault(map[string]string{
+		"GET /v1/secret/metadata/apps": `{"data": {"keys": ["db"]}}`,
+		"GET /v1/secret/data/apps/db":  `{"data": {"data": {"password": "new"}, "metadata": {"version": 1}}}`,
+	}, nil)
+	defer source.Close()
+	destination := newStubVault(nil, map[string]int{"PUT /v1/secret/data/apps/db": http.StatusNoContent})
+	defer destination.Close()
+
+	dir, err := ioutil.TempDir("", "vsync-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath, statusFile := filepath.Join(dir, "vsync.yaml"), filepath.Join(dir, "status.json")
+	writeConfig(t, configPath, source, destination, "@every 1s", "")
+	f, err := config.LoadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(f, nil)
+	r.StatusFile = statusFile
+	signals := make(chan os.Signal, 1)
+	done := make(chan error)
+	go func() {
+		done <- r.RunDaemon(signals)
+	}()
+
+	waitFor(t, "the scheduled job to write the secret", func() bool {
+		return destination.made("PUT /v1/secret/data/apps/db")
+	})
+
+	// a job added to the config file is scheduled on SIGHUP
+	writeConfig(t, configPath, source, destination, "@hourly", `  more:
+    source: staging
+    destination: prod
+    entrypoint: /secret/more
+    schedule: "@hourly"
+`)
+	signals <- syscall.SIGHUP
+	waitFor(t, "the reloaded jobs in the status file", func() bool {
+		data, _ := ioutil.ReadFile(statusFile)
+		return strings.Contains(string(data), `"job": "more"`)
+	})
+
+	// a config file that no longer loads leaves the daemon running the last good one
+	if err := ioutil.WriteFile(configPath, []byte("jobs: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	signals <- syscall.SIGHUP
+
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunDaemon() = %s, want nil once stopped", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not stop")
+	}
+}
+
+func TestRunDaemonWithoutSchedules(t *testing.T) {
+	source := newStubVault(nil, nil)
+	defer source.Close()
+	destination := newStubVault(nil, nil)
+	defer destination.Close()
+
+	r := New(testFile(source, destination, &config.Job{}), nil)
+	err := r.RunDaemon(make(chan os.Signal))
+	if err == nil || !strings.Contains(err.Error(), "there are no jobs with a schedule") {
+		t.Errorf("RunDaemon() = %v, want no scheduled jobs", err)
+	}
+}
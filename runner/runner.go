@@ -0,0 +1,222 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/flaccid/vsync/notify"
+	"github.com/flaccid/vsync/vault"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Runner runs the jobs of a config file, once or on their schedules as a daemon,
+// logging in to each endpoint once for every job that uses it
+type Runner struct {
+	// File is the config file of the jobs, Contexts are the named vaults its
+	// vault: references may also read from
+	File     *config.File
+	Contexts *config.Contexts
+
+	// Settings and DryRun are those given on the command line, for every job
+	Settings []*config.Setting
+	DryRun   bool
+
+	// RemoveOrphans removes the orphans of every job, whatever its orphans policy
+	RemoveOrphans bool
+
+	// Journal is where the jobs record their changes, a file or vault:/path in the
+	// destination vault, hmac chained with JournalKey
+	Journal    string
+	JournalKey string
+
+	// Webhooks are notified after each run of a job
+	Webhooks []*notify.Webhook
+
+	// Jobs are the jobs the daemon runs, every job with a schedule when none are given
+	Jobs []string
+
+	// StatusFile keeps the status of each job the daemon runs, MountCacheTTL is how
+	// long the mounts of each vault are cached between runs and ReloadInterval how
+	// often the config file is checked for changes, 0 to only reload on SIGHUP
+	StatusFile     string
+	MountCacheTTL  time.Duration
+	ReloadInterval time.Duration
+
+	// journals opened by the jobs, see JobJournal
+	journals      map[string]*vault.Journal
+	journalsMutex sync.Mutex
+
+	// contexts logged in to for vault: references, by name
+	referenced map[string]*config.VaultService
+
+	// connects one endpoint or context at a time, together with those its vault:
+	// references are read from, which also guards referenced, see Connect
+	connectMutex sync.Mutex
+
+	// token watchers of the endpoints logged in to, see Connect
+	watchers map[*config.VaultService]*vault.TokenWatcher
+
+	// the config file the daemon runs, the config files it replaced and the runs
+	// in progress by config file, see releaseFiles
+	daemonFile   *config.File
+	retiredFiles map[*config.File]bool
+	runningFiles map[*config.File]int
+	filesMutex   sync.Mutex
+}
+
+// New returns a runner of the jobs of a config file, which may be nil when
+// only contexts are used
+func New(f *config.File, contexts *config.Contexts) *Runner {
+	return &Runner{
+		File:         f,
+		Contexts:     contexts,
+		journals:     make(map[string]*vault.Journal),
+		referenced:   make(map[string]*config.VaultService),
+		watchers:     make(map[*config.VaultService]*vault.TokenWatcher),
+		retiredFiles: make(map[*config.File]bool),
+		runningFiles: make(map[*config.File]int),
+	}
+}
+
+// RunJobs runs jobs of the config file one after another, notifying the webhooks
+// of each, returning an error naming the jobs that failed or had failed secret paths
+func (r *Runner) RunJobs(names []string) error {
+	var failed []string
+	for _, name := range names {
+		report, err := r.RunJob(r.File, name, nil)
+		if err != nil {
+			log.Errorf("job %s failed: %s", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		if err := notify.Send(r.Webhooks, report); err != nil {
+			log.Error(err)
+		}
+		if report.HasFailures() {
+			log.Errorf("job %s: %v secret path(s) failed", name, len(report.Failed))
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%v of %v job(s) failed: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// RunJob syncs the secrets of a job from a config file, then handles
+// orphans in the destination with the job's orphans policy, stopping at
+// the next secret path when stop is closed
+func (r *Runner) RunJob(f *config.File, name string, stop <-chan struct{}) (*vault.Report, error) {
+	job, ok := f.Jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("job %s is not defined in %s", name, f.Path)
+	}
+	for _, endpoint := range []string{job.Source, job.Destination} {
+		if err := r.Connect(f, endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	jobConfig, err := f.AppConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	jobConfig.DryRun = jobConfig.DryRun || r.DryRun
+	jobConfig.Settings = r.Settings
+
+	jobClient := &vault.Client{Report: vault.NewReport(name, jobConfig.DryRun), Stop: stop}
+	jobClient.Journal, err = r.JobJournal(job.Destination, jobConfig.Destination.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := job.Orphans
+	if r.RemoveOrphans {
+		orphans = config.OrphansRemove
+	}
+
+	log.Infof("run job %s: %s %s to %s %s", name, job.Source, jobConfig.Source.VaultEntrypoint, job.Destination, jobConfig.Destination.VaultEntrypoint)
+	err = jobClient.ForEachNamespace(jobConfig, func(jobConfig *config.AppConfig) error {
+		jobClient.SyncSecrets(jobConfig)
+		if jobClient.Stopped() || (orphans != config.OrphansRemove && orphans != config.OrphansReport) {
+			return nil
+		}
+
+		orphanConfig := *jobConfig
+		// report only lists the orphans that would be removed
+		orphanConfig.DryRun = jobConfig.DryRun || orphans == config.OrphansReport
+		log.Info("fetching all secrets in destination vault, please wait...")
+		orphansFound, err := jobClient.RemoveOrphans(&orphanConfig, orphanConfig.Destination.VaultEntrypoint)
+		if err != nil {
+			return err
+		}
+		log.Infof("job %s: %v orphans found", name, len(orphansFound))
+		return nil
+	})
+	jobClient.Report.Finish()
+	if err == nil && jobClient.JournalErr() != nil {
+		err = fmt.Errorf("job %s stopped: %s", name, jobClient.JournalErr())
+	} else if err == nil && jobClient.Stopped() {
+		err = fmt.Errorf("job %s was stopped", name)
+	}
+
+	return jobClient.Report, err
+}
+
+// JobJournal returns the journal the jobs record their changes in, opened once
+// for all jobs so runs at the same time keep one chain, or once per destination
+// endpoint for a journal kept in the destination vault
+func (r *Runner) JobJournal(destination string, destinationClient *api.Client) (*vault.Journal, error) {
+	if len(r.Journal) < 1 {
+		return nil, nil
+	}
+	key := ""
+	if strings.HasPrefix(r.Journal, "vault:") {
+		key = destination
+	}
+
+	r.journalsMutex.Lock()
+	defer r.journalsMutex.Unlock()
+	if journal, ok := r.journals[key]; ok {
+		return journal, nil
+	}
+	journal, err := vault.OpenJournal(r.Journal, r.JournalKey, destinationClient)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %s", err)
+	}
+	r.journals[key] = journal
+
+	return journal, nil
+}
+
+// LoadJobStore adds the jobs kept in the job store of a config file to it, those
+// that are invalid or outside the scope of their folder are logged and left out
+func (r *Runner) LoadJobStore(f *config.File) error {
+	if f.JobStore == nil {
+		return nil
+	}
+	if err := r.Connect(f, f.JobStore.Endpoint); err != nil {
+		return err
+	}
+	service, err := f.Service(f.JobStore.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	documents, err := vault.ReadJobStore(service, f.JobStore.Path)
+	if err != nil {
+		return err
+	}
+	jobs := len(f.Jobs)
+	for _, problem := range f.AddStoredJobs(documents) {
+		log.Errorf("job store: %s", problem)
+	}
+	log.Infof("loaded %v of %v job(s) from the job store %s %s", len(f.Jobs)-jobs, len(documents), f.JobStore.Endpoint, f.JobStore.Path)
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package runner
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flaccid/vsync/config"
+)
+
+// stubVault is a stand-in vault with a kv v2 mount at secret/, answering requests
+// by method and path and recording every request made
+type stubVault struct {
+	*httptest.Server
+
+	mutex     sync.Mutex
+	responses map[string]string
+	statuses  map[string]int
+	requests  []string
+}
+
+// newStubVault starts a stand-in vault with the responses
+func newStubVault(responses map[string]string, statuses map[string]int) *stubVault {
+	v := &stubVault{responses: responses, statuses: statuses}
+	v.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r.Method + " " + r.URL.Path
+		v.mutex.Lock()
+		v.requests = append(v.requests, request)
+		v.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/sys/mounts" {
+			w.Write([]byte(`{"data": {"secret/": {"type": "kv", "options": {"version": "2"}}}}`))
+			return
+		}
+		status, ok := v.statuses[request]
+		if !ok {
+			status = http.StatusOK
+			if _, ok := v.responses[request]; !ok {
+				status = http.StatusNotFound
+			}
+		}
+		w.WriteHeader(status)
+		if body, ok := v.responses[request]; ok {
+			w.Write([]byte(body))
+		} else {
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+
+	return v
+}
+
+// made returns true when the request was made
+func (v *stubVault) made(request string) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for _, r := range v.requests {
+		if r == request {
+			return true
+		}
+	}
+
+	return false
+}
+
+// testFile returns a config file of a source and destination endpoint and a job between them
+func testFile(source, destination *stubVault, job *config.Job) *config.File {
+	job.Source, job.Destination, job.Entrypoint = "staging", "prod", "/secret/apps"
+
+	return &config.File{
+		Path: "vsync.yaml",
+		Endpoints: map[string]*config.Endpoint{
+			"staging": {Address: source.URL, Auth: &config.EndpointAuth{Token: "token"}},
+			"prod":    {Address: destination.URL, Auth: &config.EndpointAuth{Token: "token"}},
+		},
+		Jobs: map[string]*config.Job{"apps": job},
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	tests := []struct {
+		name          string
+		orphans       string
+		removeOrphans bool
+		dryRun        bool
+		wantWritten   bool
+		wantRemoved   bool
+	}{
+		{name: "keep", orphans: config.OrphansKeep, wantWritten: true},
+		{name: "report", orphans: config.OrphansReport, wantWritten: true},
+		{name: "remove", orphans: config.OrphansRemove, wantWritten: true, wantRemoved: true},
+		{name: "remove orphans given", orphans: config.OrphansKeep, removeOrphans: true, wantWritten: true, wantRemoved: true},
+		{name: "dry run", orphans: config.OrphansRemove, dryRun: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := newStubVault(map[string]string{
+				"GET /v1/secret/metadata/apps": `{"data": {"keys": ["db"]}}`,
+				"GET /v1/secret/data/apps/db":  `{"data": {"data": {"password": "new"}, "metadata": {"version": 1}}}`,
+			}, nil)
+			defer source.Close()
+			destination := newStubVault(map[string]string{
+				"GET /v1/secret/metadata/apps": `{"data": {"keys": ["db", "stale"]}}`,
+				"GET /v1/secret/data/apps/db":  `{"data": {"data": {"password": "old"}, "metadata": {"version": 1}}}`,
+			}, map[string]int{
+				"PUT /v1/secret/data/apps/db":           http.StatusNoContent,
+				"DELETE /v1/secret/metadata/apps/stale": http.StatusNoContent,
+			})
+			defer destination.Close()
+
+			r := New(testFile(source, destination, &config.Job{Orphans: test.orphans}), nil)
+			r.RemoveOrphans, r.DryRun = test.removeOrphans, test.dryRun
+			report, err := r.RunJob(r.File, "apps", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if written := destination.made("PUT /v1/secret/data/apps/db"); written != test.wantWritten {
+				t.Errorf("secret written %v, want %v", written, test.wantWritten)
+			}
+			if removed := destination.made("DELETE /v1/secret/metadata/apps/stale"); removed != test.wantRemoved {
+				t.Errorf("orphan removed %v, want %v", removed, test.wantRemoved)
+			}
+			if len(report.Changed) != 1 || report.Job != "apps" || report.DryRun != test.dryRun {
+				t.Errorf("report of job %s, dry run %v, changed %v, want the job's changed secret", report.Job, report.DryRun, report.Changed)
+			}
+		})
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	source := newStubVault(nil, nil)
+	defer source.Close()
+	destination := newStubVault(nil, nil)
+	defer destination.Close()
+
+	r := New(testFile(source, destination, &config.Job{}), nil)
+	if err := r.RunJobs([]string{"apps"}); err != nil {
+		t.Errorf("RunJobs() = %s, want nil", err)
+	}
+	err := r.RunJobs([]string{"apps", "missing"})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 job(s) failed: missing") {
+		t.Errorf("RunJobs() = %v, want the missing job failed", err)
+	}
+}
+
+func TestJobJournal(t *testing.T) {
+	source := newStubVault(nil, nil)
+	defer source.Close()
+	destination := newStubVault(nil, nil)
+	defer destination.Close()
+	r := New(testFile(source, destination, &config.Job{}), nil)
+	for _, endpoint := range []string{"staging", "prod"} {
+		if err := r.Connect(r.File, endpoint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	staging, _ := r.File.Service("staging")
+	prod, _ := r.File.Service("prod")
+
+	r.Journal, r.JournalKey = "vault:/secret/vsync/journal", "key"
+	first, err := r.JobJournal("prod", prod.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := r.JobJournal("prod", prod.Client)
+	other, _ := r.JobJournal("staging", staging.Client)
+	if first != again || first == other {
+		t.Error("journals kept in vault are not opened once per destination endpoint")
+	}
+
+	r = New(r.File, nil)
+	if journal, err := r.JobJournal("prod", prod.Client); journal != nil || err != nil {
+		t.Errorf("JobJournal() = %v, %v without a journal, want none", journal, err)
+	}
+}
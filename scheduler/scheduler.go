@@ -0,0 +1,509 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/flaccid/vsync/vault"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// results of a run in the status of a job
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+	ResultStopped   = "stopped"
+)
+
+// missedAfter is how late a run can start before it counts as missed
+const missedAfter = time.Minute
+
+// RunFunc runs a job of a config file, stopping at the next secret path when stop is closed
+type RunFunc func(f *config.File, name string, stop <-chan struct{}) (*vault.Report, error)
+
+// Status is what a scheduled job has done so far, it never holds any secret values
+type Status struct {
+	Job           string    `json:"job"`
+	Schedule      string    `json:"schedule"`
+	Concurrency   string    `json:"concurrency"`
+	MissedRuns    string    `json:"missed_runs"`
+	Running       int       `json:"running"`
+	Runs          int       `json:"runs"`
+	Failures      int       `json:"failures"`
+	Missed        int       `json:"missed"`
+	LastScheduled time.Time `json:"last_scheduled"`
+	LastStarted   time.Time `json:"last_started"`
+	LastFinished  time.Time `json:"last_finished"`
+	LastResult    string    `json:"last_result,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastChanged   int       `json:"last_changed"`
+	LastRemoved   int       `json:"last_removed"`
+	LastFailed    int       `json:"last_failed"`
+	NextRun       time.Time `json:"next_run"`
+}
+
+// Scheduler runs jobs of the config file on their cron schedules
+type Scheduler struct {
+	run        RunFunc
+	statusFile string
+	file       *config.File
+	jobs       []*job
+	started    bool
+	mutex      sync.Mutex
+	loops      sync.WaitGroup
+	runs       sync.WaitGroup
+}
+
+// job is a scheduled job and its runs in progress
+type job struct {
+	schedule cron.Schedule
+	status   *Status
+	running  []*run
+	pending  bool
+
+	// stop stops waiting for the times the job is due, on a reload or when the scheduler stops
+	stop chan struct{}
+}
+
+// run is a run of a job, waiting for the runs it replaces or in progress
+type run struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// New schedules the named jobs of the config file, the status of each job is kept
+// in the status file, when given, which is also where runs missed while the daemon
+// was not running are found
+func New(f *config.File, names []string, statusFile string, runJob RunFunc) (*Scheduler, error) {
+	previous, err := readStatus(statusFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scheduler{run: runJob, statusFile: statusFile, file: f}
+	for _, name := range names {
+		j, err := newJob(f, name)
+		if err != nil {
+			return nil, err
+		}
+		if p, ok := previous[name]; ok && p.Schedule == j.status.Schedule {
+			status := *p
+			status.Running = 0
+			status.Concurrency, status.MissedRuns = j.status.Concurrency, j.status.MissedRuns
+			j.status = &status
+		}
+		s.jobs = append(s.jobs, j)
+	}
+	if len(s.jobs) < 1 {
+		return nil, errors.New("there are no jobs to schedule")
+	}
+
+	return s, nil
+}
+
+// newJob returns a job of the config file to schedule
+func newJob(f *config.File, name string) (*job, error) {
+	j, ok := f.Jobs[name]
+	if !ok || j == nil {
+		return nil, fmt.Errorf("job %s is not defined in %s", name, f.Path)
+	}
+	if len(j.Schedule) < 1 {
+		return nil, fmt.Errorf("job %s has no schedule", name)
+	}
+	schedule, err := cron.ParseStandard(j.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("job %s: invalid schedule %q: %s", name, j.Schedule, err)
+	}
+
+	return &job{
+		schedule: schedule,
+		status: &Status{
+			Job:         name,
+			Schedule:    j.Schedule,
+			Concurrency: orDefault(j.Concurrency, config.ConcurrencyForbid),
+			MissedRuns:  orDefault(j.MissedRuns, config.MissedRunsSkip),
+		},
+	}, nil
+}
+
+// Run runs the jobs on their schedules until stop is closed, then stops the
+// runs in progress and waits for them to finish
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	s.mutex.Lock()
+	s.started = true
+	for _, j := range s.jobs {
+		s.schedule(j)
+	}
+	s.mutex.Unlock()
+
+	<-stop
+
+	s.mutex.Lock()
+	s.started = false
+	for _, j := range s.jobs {
+		close(j.stop)
+		j.pending = false
+		for _, r := range j.running {
+			r.cancel()
+		}
+	}
+	s.mutex.Unlock()
+	s.loops.Wait()
+	s.runs.Wait()
+}
+
+// Reload schedules the named jobs of a new config file in place of the current
+// ones, the runs in progress finish with the config file they started with and
+// jobs whose schedule did not change keep their status, on an error nothing changes
+func (s *Scheduler) Reload(f *config.File, names []string) error {
+	jobs := make([]*job, 0, len(names))
+	for _, name := range names {
+		j, err := newJob(f, name)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, j)
+	}
+	if len(jobs) < 1 {
+		return errors.New("there are no jobs to schedule")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.save()
+
+	current := make(map[string]*job)
+	for _, j := range s.jobs {
+		current[j.status.Job] = j
+	}
+	for i, j := range jobs {
+		previous, ok := current[j.status.Job]
+		if !ok {
+			log.Infof("job %s is scheduled %s", j.status.Job, j.status.Schedule)
+			s.schedule(j)
+			continue
+		}
+		delete(current, j.status.Job)
+
+		// the same job keeps its runs in progress, which its policies still apply to
+		previous.status.Concurrency, previous.status.MissedRuns = j.status.Concurrency, j.status.MissedRuns
+		jobs[i] = previous
+		if previous.status.Schedule == j.status.Schedule {
+			continue
+		}
+		log.Infof("job %s is rescheduled from %s to %s", j.status.Job, previous.status.Schedule, j.status.Schedule)
+		close(previous.stop)
+		previous.schedule = j.schedule
+		previous.status.Schedule = j.status.Schedule
+		previous.status.LastScheduled = time.Time{}
+		s.schedule(previous)
+	}
+	for name, j := range current {
+		log.Infof("job %s is no longer scheduled", name)
+		close(j.stop)
+		j.pending = false
+	}
+
+	s.file = f
+	s.jobs = jobs
+
+	return nil
+}
+
+// schedule starts waiting for the times a job is due, the scheduler must be locked
+func (s *Scheduler) schedule(j *job) {
+	if !s.started {
+		return
+	}
+	j.stop = make(chan struct{})
+
+	s.loops.Add(1)
+	go func(schedule cron.Schedule, stop chan struct{}) {
+		defer s.loops.Done()
+		s.loop(j, schedule, stop)
+	}(j.schedule, j.stop)
+}
+
+// loop waits for each time the job is due until stop is closed
+func (s *Scheduler) loop(j *job, schedule cron.Schedule, stop <-chan struct{}) {
+	s.mutex.Lock()
+	last := j.status.LastScheduled
+	s.mutex.Unlock()
+	if last.IsZero() {
+		last = time.Now()
+	}
+
+	for {
+		next := schedule.Next(last)
+		s.mutex.Lock()
+		if stopped(stop) {
+			s.mutex.Unlock()
+			return
+		}
+		j.status.NextRun = next.UTC()
+		s.save()
+		s.mutex.Unlock()
+		log.Debugf("job %s is next due at %s", j.status.Job, next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// when late, e.g. the daemon was down or suspended, every time due since is missed
+		now := time.Now()
+		missed := 0
+		for due := next; !due.After(now); due = schedule.Next(due) {
+			if now.Sub(due) > missedAfter {
+				missed++
+			}
+			last = due
+		}
+		s.due(j, last, missed, stop)
+	}
+}
+
+// due starts a run of a job that is due, or catching up on missed runs,
+// with its concurrency policy
+func (s *Scheduler) due(j *job, scheduled time.Time, missed int, stop <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stopped(stop) {
+		return
+	}
+	defer s.save()
+
+	status := j.status
+	status.LastScheduled = scheduled.UTC()
+	// the last time due is on time unless it is missed as well
+	onTime := time.Since(scheduled) <= missedAfter
+	if missed > 0 {
+		status.Missed += missed
+		log.Warnf("job %s missed %v run(s)", status.Job, missed)
+		if !onTime && status.MissedRuns != config.MissedRunsRun {
+			return
+		}
+		if !onTime {
+			log.Infof("job %s: catching up on missed runs", status.Job)
+		}
+	}
+
+	if len(j.running) < 1 {
+		s.start(j, nil)
+		return
+	}
+	switch status.Concurrency {
+	case config.ConcurrencyAllow:
+		s.start(j, nil)
+	case config.ConcurrencyReplace:
+		log.Infof("job %s is still running, replacing the run in progress", status.Job)
+		var replaced []chan struct{}
+		for _, r := range j.running {
+			r.cancel()
+			replaced = append(replaced, r.done)
+		}
+		s.start(j, replaced)
+	default:
+		status.Missed++
+		if status.MissedRuns == config.MissedRunsRun {
+			log.Warnf("job %s is still running, it runs again when it finishes", status.Job)
+			j.pending = true
+			return
+		}
+		log.Warnf("job %s is still running, skipping this run", status.Job)
+	}
+}
+
+// start starts a run of the job once the runs it replaces have finished,
+// the scheduler must be locked
+func (s *Scheduler) start(j *job, replaced []chan struct{}) {
+	r := &run{stop: make(chan struct{}), done: make(chan struct{})}
+	j.running = append(j.running, r)
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+		for _, done := range replaced {
+			<-done
+		}
+		s.execute(j, r)
+	}()
+}
+
+// execute runs the job and records how it went in its status
+func (s *Scheduler) execute(j *job, r *run) {
+	name := j.status.Job
+	s.mutex.Lock()
+	j.status.Running++
+	j.status.LastStarted = time.Now().UTC()
+	// the run keeps the config file it starts with, whatever is reloaded meanwhile
+	f := s.file
+	s.save()
+	s.mutex.Unlock()
+
+	var report *vault.Report
+	var err error
+	select {
+	case <-r.stop:
+		// replaced or stopped before it started
+	default:
+		log.Infof("job %s started", name)
+		report, err = s.run(f, name, r.stop)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.save()
+
+	for i, running := range j.running {
+		if running == r {
+			j.running = append(j.running[:i], j.running[i+1:]...)
+			break
+		}
+	}
+	close(r.done)
+
+	status := j.status
+	status.Running--
+	status.Runs++
+	status.LastFinished = time.Now().UTC()
+	status.LastError = ""
+	status.LastChanged, status.LastRemoved, status.LastFailed = 0, 0, 0
+	if report != nil {
+		status.LastChanged, status.LastRemoved, status.LastFailed = len(report.Changed), len(report.Removed), len(report.Failed)
+	}
+	switch {
+	case stopped(r.stop):
+		status.LastResult = ResultStopped
+	case err != nil:
+		status.LastResult = ResultFailed
+		status.LastError = err.Error()
+	case report.HasFailures():
+		status.LastResult = ResultFailed
+		status.LastError = fmt.Sprintf("%v secret path(s) failed", len(report.Failed))
+	default:
+		status.LastResult = ResultSucceeded
+	}
+	if status.LastResult == ResultFailed {
+		status.Failures++
+	}
+
+	fields := log.Fields{
+		"job":     name,
+		"result":  status.LastResult,
+		"changed": status.LastChanged,
+		"removed": status.LastRemoved,
+		"failed":  status.LastFailed,
+		"took":    status.LastFinished.Sub(status.LastStarted).Round(time.Millisecond).String(),
+	}
+	if status.LastResult == ResultFailed {
+		log.WithFields(fields).Errorf("job %s failed: %s", name, status.LastError)
+	} else {
+		log.WithFields(fields).Infof("job %s %s", name, status.LastResult)
+	}
+
+	if j.pending && len(j.running) < 1 {
+		j.pending = false
+		log.Infof("job %s: catching up on the run missed while it was running", name)
+		s.start(j, nil)
+	}
+}
+
+// save writes the status of every job to the status file, the scheduler must be locked
+func (s *Scheduler) save() {
+	if len(s.statusFile) < 1 {
+		return
+	}
+
+	data, err := json.MarshalIndent(s.statuses(), "", "    ")
+	if err != nil {
+		log.Errorf("unable to save the job status: %s", err)
+		return
+	}
+	// written in full then renamed so readers never see a partial file
+	tmp := filepath.Join(filepath.Dir(s.statusFile), "."+filepath.Base(s.statusFile)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		log.Errorf("unable to save the job status: %s", err)
+		return
+	}
+	if err := os.Rename(tmp, s.statusFile); err != nil {
+		log.Errorf("unable to save the job status: %s", err)
+	}
+}
+
+// statuses copies the status of every job, sorted by job name, the scheduler must be locked
+func (s *Scheduler) statuses() []*Status {
+	statuses := make([]*Status, len(s.jobs))
+	for i, j := range s.jobs {
+		status := *j.status
+		statuses[i] = &status
+	}
+	sort.Slice(statuses, func(i, k int) bool { return statuses[i].Job < statuses[k].Job })
+
+	return statuses
+}
+
+// readStatus reads the status of the jobs from a previous run of the daemon, by job name
+func readStatus(statusFile string) (map[string]*Status, error) {
+	previous := make(map[string]*Status)
+	if len(statusFile) < 1 {
+		return previous, nil
+	}
+
+	data, err := ioutil.ReadFile(statusFile)
+	if os.IsNotExist(err) {
+		return previous, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read status file: %s", err)
+	}
+
+	var statuses []*Status
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, fmt.Errorf("unable to parse status file %s: %s", statusFile, err)
+	}
+	for _, status := range statuses {
+		previous[status.Job] = status
+	}
+
+	return previous, nil
+}
+
+// cancel stops the run
+func (r *run) cancel() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// stopped returns true when the channel is closed
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// orDefault returns the value, or the default when empty
+func orDefault(value, defaultValue string) string {
+	if len(value) < 1 {
+		return defaultValue
+	}
+
+	return value
+}
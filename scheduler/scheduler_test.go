@@ -0,0 +1,217 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/flaccid/vsync/vault"
+)
+
+// testRuns is a stand-in for running jobs, each run waits to be released or stopped
+type testRuns struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newTestRuns() *testRuns {
+	return &testRuns{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+// run is the RunFunc of the stand-in
+func (r *testRuns) run(f *config.File, name string, stop <-chan struct{}) (*vault.Report, error) {
+	r.started <- struct{}{}
+	select {
+	case <-r.release:
+	case <-stop:
+	}
+
+	return vault.NewReport(name, false), nil
+}
+
+// waitStarted waits for a run to start
+func (r *testRuns) waitStarted(t *testing.T) {
+	select {
+	case <-r.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a run to start")
+	}
+}
+
+// testScheduler returns a scheduler of a single job with the policies
+func testScheduler(t *testing.T, concurrency, missedRuns string, runs *testRuns) (*Scheduler, *job) {
+	f := &config.File{Path: "vsync.yaml", Jobs: map[string]*config.Job{
+		"payments": {Schedule: "@hourly", Concurrency: concurrency, MissedRuns: missedRuns},
+	}}
+	s, err := New(f, []string{"payments"}, "", runs.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, s.jobs[0]
+}
+
+// status returns a copy of the status of the job
+func (s *Scheduler) status(j *job) Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return *j.status
+}
+
+func TestMissedRuns(t *testing.T) {
+	tests := []struct {
+		name       string
+		missedRuns string
+		late       time.Duration
+		wantRuns   int
+	}{
+		{name: "skipped", missedRuns: config.MissedRunsSkip, late: 5 * time.Minute, wantRuns: 0},
+		{name: "caught up", missedRuns: config.MissedRunsRun, late: 5 * time.Minute, wantRuns: 1},
+		{name: "on time after missed runs", missedRuns: config.MissedRunsSkip, late: 0, wantRuns: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runs := newTestRuns()
+			close(runs.release)
+			s, j := testScheduler(t, "", test.missedRuns, runs)
+
+			s.due(j, time.Now().Add(-test.late), 3, make(chan struct{}))
+			s.runs.Wait()
+
+			status := s.status(j)
+			if status.Missed != 3 {
+				t.Errorf("missed %v run(s), want 3", status.Missed)
+			}
+			if status.Runs != test.wantRuns {
+				t.Errorf("ran %v time(s), want %v", status.Runs, test.wantRuns)
+			}
+		})
+	}
+}
+
+func TestMissedWhileStopped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsync-scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statusFile := filepath.Join(dir, "status.json")
+
+	// the daemon was stopped for longer than the schedule's interval
+	previous, _ := json.Marshal([]*Status{{Job: "payments", Schedule: "@every 7m", LastScheduled: time.Now().Add(-10*time.Minute - 30*time.Second)}})
+	if err := ioutil.WriteFile(statusFile, previous, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := newTestRuns()
+	close(runs.release)
+	f := &config.File{Path: "vsync.yaml", Jobs: map[string]*config.Job{"payments": {Schedule: "@every 7m"}}}
+	s, err := New(f, []string{"payments"}, statusFile, runs.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.status(s.jobs[0]).Missed < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	status := s.status(s.jobs[0])
+	if status.Missed != 1 || status.Runs != 0 {
+		t.Errorf("missed %v and ran %v time(s), want the one run due while stopped skipped", status.Missed, status.Runs)
+	}
+	if until := time.Until(status.NextRun); until < 3*time.Minute || until > 4*time.Minute {
+		t.Errorf("next run in %s, want the next time due after the missed one", until)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency string
+		missedRuns  string
+		wantRunning int
+		wantMissed  int
+		wantRuns    int
+		wantResult  string
+	}{
+		{name: "forbid", concurrency: config.ConcurrencyForbid, wantRunning: 1, wantMissed: 1, wantRuns: 1, wantResult: ResultSucceeded},
+		{name: "forbid and run again", concurrency: config.ConcurrencyForbid, missedRuns: config.MissedRunsRun, wantRunning: 1, wantMissed: 1, wantRuns: 2, wantResult: ResultSucceeded},
+		{name: "replace", concurrency: config.ConcurrencyReplace, wantRunning: 1, wantRuns: 2, wantResult: ResultSucceeded},
+		{name: "allow", concurrency: config.ConcurrencyAllow, wantRunning: 2, wantRuns: 2, wantResult: ResultSucceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runs := newTestRuns()
+			s, j := testScheduler(t, test.concurrency, test.missedRuns, runs)
+			stop := make(chan struct{})
+
+			s.due(j, time.Now(), 0, stop)
+			runs.waitStarted(t)
+			s.due(j, time.Now(), 0, stop)
+			if test.concurrency != config.ConcurrencyForbid {
+				runs.waitStarted(t)
+			}
+
+			status := s.status(j)
+			if status.Running != test.wantRunning {
+				t.Errorf("%v run(s) in progress, want %v", status.Running, test.wantRunning)
+			}
+			if status.Missed != test.wantMissed {
+				t.Errorf("missed %v run(s), want %v", status.Missed, test.wantMissed)
+			}
+
+			close(runs.release)
+			s.runs.Wait()
+			status = s.status(j)
+			if status.Runs != test.wantRuns || status.Running != 0 {
+				t.Errorf("ran %v time(s) with %v in progress, want %v and none", status.Runs, status.Running, test.wantRuns)
+			}
+			if status.LastResult != test.wantResult {
+				t.Errorf("last result %s, want %s", status.LastResult, test.wantResult)
+			}
+		})
+	}
+}
+
+func TestReplacedRunStops(t *testing.T) {
+	runs := newTestRuns()
+	s, j := testScheduler(t, config.ConcurrencyReplace, "", runs)
+	stop := make(chan struct{})
+
+	s.due(j, time.Now(), 0, stop)
+	runs.waitStarted(t)
+	s.mutex.Lock()
+	first := j.running[0]
+	s.mutex.Unlock()
+
+	s.due(j, time.Now(), 0, stop)
+	if !stopped(first.stop) {
+		t.Error("the run in progress was not stopped")
+	}
+	// the new run only starts once the replaced one has finished
+	runs.waitStarted(t)
+	select {
+	case <-first.done:
+	default:
+		t.Error("the new run started before the replaced run finished")
+	}
+
+	close(runs.release)
+	s.runs.Wait()
+}
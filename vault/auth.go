@@ -0,0 +1,344 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// supported auth methods
+const (
+	AuthAgent      = "agent"
+	AuthAppRole    = "approle"
+	AuthCert       = "cert"
+	AuthJWT        = "jwt"
+	AuthKubernetes = "kubernetes"
+	AuthToken      = "token"
+	AuthUserpass   = "userpass"
+)
+
+// ServiceAccountTokenFile is where kubernetes mounts the pod's service account token
+const ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// credentials is the content of a credentials file
+type credentials struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// login authenticates the client using the service's auth method
+// and sets the resulting token on the client
+func login(client *api.Client, service *config.VaultService) error {
+	// a wrapping token is exchanged for the credentials it wraps first
+	if len(service.WrappedToken) > 0 {
+		return loginWrapped(client, service)
+	}
+
+	method := authMethod(service)
+	log.Debugf("login to %s with auth method %s", client.Address(), method)
+
+	switch method {
+	case AuthToken:
+		token, err := discoverToken(service)
+		if err != nil {
+			return err
+		}
+		if len(token) < 1 {
+			// never fall back to the token the api client read from VAULT_TOKEN
+			// as it belongs to the source vault
+			log.Debugf("no token found for %s", client.Address())
+			client.ClearToken()
+			return nil
+		}
+		setToken(client, token)
+		return nil
+	case AuthUserpass:
+		return loginUserpass(client, service)
+	case AuthAppRole:
+		return loginAppRole(client, service)
+	case AuthKubernetes:
+		return loginKubernetes(client, service)
+	case AuthJWT:
+		return loginJWT(client, service)
+	case AuthCert:
+		return loginCert(client, service)
+	case AuthAgent:
+		token, err := readSinkToken(service)
+		if err != nil {
+			return err
+		}
+		setToken(client, token)
+		return nil
+	}
+
+	return fmt.Errorf("unsupported auth method %s", method)
+}
+
+// authMethod returns the auth method used for a vault, when not set explicitly
+// userpass is used if a username or credentials file is provided, approle
+// if a role id is provided and agent if a sink file is provided
+func authMethod(service *config.VaultService) string {
+	if len(service.AuthMethod) > 0 {
+		return service.AuthMethod
+	}
+	if len(service.VaultUsername) > 0 || len(service.VaultCredFile) > 0 {
+		return AuthUserpass
+	}
+	if len(service.RoleID) > 0 || len(service.RoleIDFile) > 0 {
+		return AuthAppRole
+	}
+	if len(service.AgentSinkFile) > 0 {
+		return AuthAgent
+	}
+
+	return AuthToken
+}
+
+// authMount returns the path the service's auth method is mounted at
+func authMount(service *config.VaultService) string {
+	if len(service.AuthMount) > 0 {
+		return service.AuthMount
+	}
+
+	return authMethod(service)
+}
+
+// loginUserpass logs in with a username and password, from the service
+// or its credentials file
+func loginUserpass(client *api.Client, service *config.VaultService) error {
+	username, password := service.VaultUsername, service.VaultPassword
+	if len(service.VaultCredFile) > 0 {
+		creds, err := readCredentials(service.VaultCredFile)
+		if err != nil {
+			return err
+		}
+		// explicitly provided values take precedence over the file
+		if len(username) < 1 {
+			username = creds.Username
+		}
+		if len(password) < 1 {
+			password = creds.Password
+		}
+	}
+	if len(username) < 1 || len(password) < 1 {
+		return errors.New("a username and password are required for userpass authentication")
+	}
+
+	// the username is a single path segment whatever it contains, which the api
+	// client's logical writes would clean or escape again
+	request := client.NewRequest("PUT", fmt.Sprintf("/%s/auth/%s/login", apiVersion, authMount(service)))
+	request.URL.RawPath = request.URL.EscapedPath() + "/" + url.PathEscape(username)
+	request.URL.Path += "/" + username
+	if err := request.SetJSONBody(map[string]interface{}{"password": password}); err != nil {
+		return err
+	}
+	resp, err := client.RawRequest(request)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("userpass login as %s failed: %s", username, err)
+	}
+	secret, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return fmt.Errorf("userpass login as %s failed: %s", username, err)
+	}
+
+	return setLoginToken(client, secret)
+}
+
+// loginAppRole logs in with a role id and secret id, either of which may be
+// read from a file, unwrapping the secret id first if it is response-wrapped
+func loginAppRole(client *api.Client, service *config.VaultService) error {
+	roleID, err := valueOrFile(service.RoleID, service.RoleIDFile)
+	if err != nil {
+		return fmt.Errorf("unable to read role id: %s", err)
+	}
+	secretID, err := valueOrFile(service.SecretID, service.SecretIDFile)
+	if err != nil {
+		return fmt.Errorf("unable to read secret id: %s", err)
+	}
+	if len(roleID) < 1 {
+		return errors.New("a role id is required for approle authentication")
+	}
+
+	if service.SecretIDWrapped && len(secretID) > 0 {
+		unwrapped, err := unwrap(client, secretID)
+		if err != nil {
+			return fmt.Errorf("unable to unwrap secret id: %s", err)
+		}
+		id, ok := unwrapped.Data["secret_id"].(string)
+		if !ok || len(id) < 1 {
+			return errors.New("wrapped response did not contain a secret_id")
+		}
+		secretID = id
+	}
+
+	data := map[string]interface{}{"role_id": roleID}
+	// a role may be configured to not require a secret id
+	if len(secretID) > 0 {
+		data["secret_id"] = secretID
+	}
+	secret, err := client.Logical().Write(fmt.Sprintf("auth/%s/login", authMount(service)), data)
+	if err != nil {
+		return fmt.Errorf("approle login failed: %s", err)
+	}
+
+	return setLoginToken(client, secret)
+}
+
+// loginKubernetes logs in as a role with the pod's service account token,
+// or the jwt file when one is given
+func loginKubernetes(client *api.Client, service *config.VaultService) error {
+	if len(service.Role) < 1 {
+		return errors.New("a role is required for kubernetes authentication")
+	}
+	file := service.JWTFile
+	if len(file) < 1 {
+		file = ServiceAccountTokenFile
+	}
+	jwt, err := valueOrFile("", file)
+	if err != nil {
+		return fmt.Errorf("unable to read service account token: %s", err)
+	}
+
+	return loginWithJWT(client, service, jwt)
+}
+
+// loginJWT logs in with a jwt, such as an oidc token issued to a ci job,
+// given directly or read from a file
+func loginJWT(client *api.Client, service *config.VaultService) error {
+	jwt, err := valueOrFile(service.JWT, service.JWTFile)
+	if err != nil {
+		return fmt.Errorf("unable to read jwt: %s", err)
+	}
+	if len(jwt) < 1 {
+		return errors.New("a jwt or jwt file is required for jwt authentication")
+	}
+
+	return loginWithJWT(client, service, jwt)
+}
+
+// loginWithJWT writes the jwt and role, when set, to the auth method's login path
+func loginWithJWT(client *api.Client, service *config.VaultService, jwt string) error {
+	data := map[string]interface{}{"jwt": jwt}
+	// the jwt auth method falls back to its default role
+	if len(service.Role) > 0 {
+		data["role"] = service.Role
+	}
+
+	method := authMethod(service)
+	secret, err := client.Logical().Write(fmt.Sprintf("auth/%s/login", authMount(service)), data)
+	if err != nil {
+		return fmt.Errorf("%s login as role %s failed: %s", method, service.Role, err)
+	}
+
+	return setLoginToken(client, secret)
+}
+
+// loginCert logs in with the client certificate presented over tls, as the
+// named certificate role when one is set
+func loginCert(client *api.Client, service *config.VaultService) error {
+	if service.TLS == nil || len(service.TLS.ClientCert) < 1 {
+		return errors.New("a client certificate and key are required for cert authentication")
+	}
+
+	data := map[string]interface{}{}
+	if len(service.Role) > 0 {
+		data["name"] = service.Role
+	}
+	secret, err := client.Logical().Write(fmt.Sprintf("auth/%s/login", authMount(service)), data)
+	if err != nil {
+		return fmt.Errorf("cert login with %s failed: %s", service.TLS.ClientCert, err)
+	}
+
+	return setLoginToken(client, secret)
+}
+
+// readSinkToken reads the token vault agent's auto-auth wrote to its file sink
+func readSinkToken(service *config.VaultService) (string, error) {
+	if len(service.AgentSinkFile) < 1 {
+		return "", errors.New("an agent sink file is required for agent authentication")
+	}
+	token, err := valueOrFile("", service.AgentSinkFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read agent sink: %s", err)
+	}
+	if len(token) < 1 {
+		return "", fmt.Errorf("agent sink %s is empty, the agent has not authenticated yet", service.AgentSinkFile)
+	}
+	// wrapped and encrypted sinks are written as json
+	if strings.HasPrefix(token, "{") {
+		return "", fmt.Errorf("agent sink %s is wrapped or encrypted, only plain token sinks are supported", service.AgentSinkFile)
+	}
+
+	return token, nil
+}
+
+// unwrap returns the response wrapped by a single-use wrapping token
+func unwrap(client *api.Client, wrappingToken string) (*api.Secret, error) {
+	// use a clone so the client's own token is never sent or replaced
+	c, err := client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	c.SetHeaders(client.Headers())
+	c.SetToken(wrappingToken)
+
+	secret, err := c.Logical().Unwrap("")
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("wrapping token returned no response")
+	}
+
+	return secret, nil
+}
+
+// valueOrFile returns the value, or when empty the trimmed content of the file
+func valueOrFile(value, file string) (string, error) {
+	if len(value) > 0 || len(file) < 1 {
+		return value, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// setLoginToken sets the token from a login response on the client
+func setLoginToken(client *api.Client, secret *api.Secret) error {
+	if secret == nil || secret.Auth == nil || len(secret.Auth.ClientToken) < 1 {
+		return errors.New("login response did not include a token")
+	}
+	setToken(client, secret.Auth.ClientToken)
+	log.Debugf("logged in to %s, token accessor %s", client.Address(), secret.Auth.Accessor)
+
+	return nil
+}
+
+// readCredentials reads a json or yaml credentials file
+func readCredentials(path string) (*credentials, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file: %s", err)
+	}
+
+	// json is a subset of yaml so both are parsed the same way
+	creds := &credentials{}
+	if err := yaml.Unmarshal(data, creds); err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file %s: %s", path, err)
+	}
+
+	return creds, nil
+}
@@ -0,0 +1,228 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
+)
+
+// stubVault starts a stand-in vault answering every request with the handler,
+// returning a service for it to connect to
+func stubVault(handler http.HandlerFunc) (*httptest.Server, *config.VaultService) {
+	server := httptest.NewServer(handler)
+
+	return server, &config.VaultService{Vault: &api.Config{Address: server.URL}}
+}
+
+// loginRequest is a login request made to a stand-in vault
+type loginRequest struct {
+	path  string
+	token string
+	body  map[string]interface{}
+}
+
+// loginHandler records the login requests and answers them with a client token
+func loginHandler(requests *[]loginRequest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := loginRequest{path: r.URL.EscapedPath(), token: r.Header.Get("X-Vault-Token")}
+		json.NewDecoder(r.Body).Decode(&request.body)
+		*requests = append(*requests, request)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"auth": {"client_token": "logged-in", "accessor": "accessor"}}`))
+	}
+}
+
+// tempFile writes the content to a temporary file, returning its name
+func tempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "vsync-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestLoginKubernetes(t *testing.T) {
+	jwtFile := tempFile(t, "service-account-jwt\n")
+	defer os.Remove(jwtFile)
+
+	tests := []struct {
+		name     string
+		mount    string
+		role     string
+		wantPath string
+		wantErr  bool
+	}{
+		{name: "default mount", role: "vsync", wantPath: "/v1/auth/kubernetes/login"},
+		{name: "custom mount", mount: "k8s-prod", role: "vsync", wantPath: "/v1/auth/k8s-prod/login"},
+		{name: "no role", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []loginRequest
+			server, service := stubVault(loginHandler(&requests))
+			defer server.Close()
+			service.AuthMethod = AuthKubernetes
+			service.AuthMount = test.mount
+			service.Role = test.role
+			service.JWTFile = jwtFile
+
+			client, err := Connect(service)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if len(requests) > 0 {
+					t.Errorf("expected no login request, got %v", requests)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(requests) != 1 {
+				t.Fatalf("expected 1 login request, got %v", len(requests))
+			}
+			request := requests[0]
+			if request.path != test.wantPath {
+				t.Errorf("login path %s, want %s", request.path, test.wantPath)
+			}
+			if request.body["jwt"] != "service-account-jwt" || request.body["role"] != test.role {
+				t.Errorf("login body %v, want the jwt and role %s", request.body, test.role)
+			}
+			if client.Token() != "logged-in" {
+				t.Errorf("client token %q, want the login's token", client.Token())
+			}
+		})
+	}
+}
+
+func TestLoginJWT(t *testing.T) {
+	jwtFile := tempFile(t, "file-jwt\n")
+	defer os.Remove(jwtFile)
+
+	tests := []struct {
+		name    string
+		jwt     string
+		jwtFile string
+		role    string
+		wantJWT string
+		wantErr bool
+	}{
+		{name: "jwt", jwt: "ci-jwt", role: "ci-sync", wantJWT: "ci-jwt"},
+		{name: "jwt file", jwtFile: jwtFile, wantJWT: "file-jwt"},
+		{name: "jwt over file", jwt: "ci-jwt", jwtFile: jwtFile, wantJWT: "ci-jwt"},
+		{name: "no jwt", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []loginRequest
+			server, service := stubVault(loginHandler(&requests))
+			defer server.Close()
+			service.AuthMethod = AuthJWT
+			service.JWT = test.jwt
+			service.JWTFile = test.jwtFile
+			service.Role = test.role
+
+			client, err := Connect(service)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(requests) != 1 || requests[0].path != "/v1/auth/jwt/login" {
+				t.Fatalf("expected a login to /v1/auth/jwt/login, got %v", requests)
+			}
+			if requests[0].body["jwt"] != test.wantJWT {
+				t.Errorf("login jwt %v, want %s", requests[0].body["jwt"], test.wantJWT)
+			}
+			// the auth method's default role is used when none is given
+			if role, ok := requests[0].body["role"]; ok != (len(test.role) > 0) || (ok && role != test.role) {
+				t.Errorf("login role %v, want %q", role, test.role)
+			}
+			if client.Token() != "logged-in" {
+				t.Errorf("client token %q, want the login's token", client.Token())
+			}
+		})
+	}
+}
+
+func TestLoginUserpass(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		mount    string
+		wantPath string
+	}{
+		{name: "username", username: "ci", wantPath: "/v1/auth/userpass/login/ci"},
+		{name: "custom mount", username: "ci", mount: "ldap-users", wantPath: "/v1/auth/ldap-users/login/ci"},
+		{name: "escaped username", username: "team/ci?x#..", wantPath: "/v1/auth/userpass/login/team%2Fci%3Fx%23.."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests []loginRequest
+			server, service := stubVault(loginHandler(&requests))
+			defer server.Close()
+			service.AuthMount = test.mount
+			service.VaultUsername = test.username
+			service.VaultPassword = "password"
+
+			client, err := Connect(service)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(requests) != 1 || requests[0].path != test.wantPath {
+				t.Fatalf("expected a login to %s, got %v", test.wantPath, requests)
+			}
+			if requests[0].body["password"] != "password" {
+				t.Errorf("login body %v, want the password", requests[0].body)
+			}
+			if client.Token() != "logged-in" {
+				t.Errorf("client token %q, want the login's token", client.Token())
+			}
+		})
+	}
+}
+
+func TestLoginWithoutInheritedToken(t *testing.T) {
+	// the api client reads VAULT_TOKEN, which is the source vault's token
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Setenv("VAULT_TOKEN", "source-token")
+
+	var requests []loginRequest
+	server, service := stubVault(loginHandler(&requests))
+	defer server.Close()
+	service.AuthMethod = AuthAppRole
+	service.RoleID = "role-id"
+	service.SecretID = "secret-id"
+
+	if _, err := Connect(service); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 login request, got %v", len(requests))
+	}
+	if requests[0].token != "" {
+		t.Errorf("login sent the token %q, want none", requests[0].token)
+	}
+}
@@ -2,9 +2,10 @@ package vault
 
 import (
 	"crypto/tls"
-	"io/ioutil"
+	"fmt"
 	"net/http"
-	"os"
+	"net/url"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -15,74 +16,88 @@ import (
 // TODO: look at completing the remaining auth methods from
 // https://github.com/cloudwatt/vault-sync/blob/master/pkg/vault/vault.go
 
+// New returns a client for the source vault, logged in with its auth method
 func New(c *config.AppConfig) (client *api.Client, err error) {
 	log.Debugf("create vault client to: %s", c.Source.Vault.Address)
 
-	if len(c.Source.VaultToken) < 1 {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			log.Fatalf("unable to detect home directory", err)
-		}
-
-		_, err = os.Stat(homeDir + "/.vault-token")
- 		if err != nil {
-			data, err := ioutil.ReadFile(homeDir + "/.vault-token")
-			if err != nil {
-				log.Fatalf("file reading error", err)
-			}
-			c.Source.VaultToken = string(data)
-			// uncomment to debug vault token (insecure!)
-			// log.Debug("vault token: "+c.VaultToken)
-		}
-	}
+	return newClient(c.Source)
+}
 
-	// step: get the client configuration
-	config := api.DefaultConfig()
-	config.Address = c.Source.Vault.Address
-	config.HttpClient = &http.Client{
-		Timeout: time.Duration(15) * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
-	}
+// NewDest returns a client for the destination vault, logged in with its auth method
+func NewDest(c *config.AppConfig) (*api.Client, error) {
+	log.Debugf("create destination vault client to: %s", c.Destination.Vault.Address)
 
-	// step: get the client
-	client, err = api.NewClient(config)
-	if err != nil {
-		log.Fatalf("error creating client ", err)
-	}
+	return newClient(c.Destination)
+}
 
-	// step: set the tocken for the client to use
-	client.SetToken(c.Source.VaultToken)
+// Connect returns a client for a vault service, such as a config file endpoint,
+// logged in with its auth method
+func Connect(service *config.VaultService) (*api.Client, error) {
+	log.Debugf("create vault client to: %s", service.Vault.Address)
 
-	return client, nil
+	return newClient(service)
 }
 
-func NewDest(c *config.AppConfig) (*api.Client, error) {
-	log.Debugf("create destination vault client to: %s", c.Destination.Vault.Address)
-
+// newClient creates a vault api client for the service and logs in
+func newClient(service *config.VaultService) (*api.Client, error) {
 	// step: get the client configuration
 	config := api.DefaultConfig()
-	config.Address = c.Destination.Vault.Address
+	config.Address = service.Vault.Address
+	// the api client would send every request to VAULT_AGENT_ADDR instead, for
+	// the destination too, an agent is used by giving its address to the vault
+	config.AgentAddress = ""
 	config.HttpClient = &http.Client{
 		Timeout: time.Duration(15) * time.Second,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
+				MinVersion: tls.VersionTLS12,
 			},
 		},
 	}
 
+	// step: ca certificates, client certificate, server name and verification
+	if service.TLS != nil {
+		if service.TLS.Insecure {
+			log.Warnf("tls certificate verification is disabled for %s", service.Vault.Address)
+		}
+		if err := config.ConfigureTLS(service.TLS); err != nil {
+			return nil, fmt.Errorf("unable to configure tls: %s", err)
+		}
+	}
+
+	service.Vault.HttpClient = config.HttpClient
+
 	// step: get the client
 	client, err := api.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
 
-	// step: set the tocken for the client to use
-	client.SetToken(c.Destination.VaultToken)
+	// step: make every request in the namespace, including logging in
+	setNamespace(client, service.Namespace)
+
+	// step: drop the token the api client read from VAULT_TOKEN, it belongs to
+	// the source vault and must not be sent to a login path, only the token,
+	// agent and wrapped auth methods set a token without logging in
+	client.ClearToken()
+
+	// step: log in, setting the token for the client to use
+	err = login(client, service)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// displayAddress returns the address of a client as it was given, the api
+// client rewrites unix socket addresses to http with the socket as the host
+func displayAddress(client *api.Client) string {
+	address := client.Address()
+	socket, err := url.PathUnescape(strings.TrimPrefix(address, "http://"))
+	if err != nil || !strings.HasPrefix(socket, "/") {
+		return address
+	}
 
-	return client, err
+	return "unix://" + socket
 }
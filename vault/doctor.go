@@ -0,0 +1,277 @@
+package vault
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// check results
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+
+	// doctorProbe is the secret name used to ask for capabilities below an entrypoint
+	doctorProbe = "vsync-doctor-probe"
+
+	// tokenTTLWarning is the remaining token ttl below which a warning is raised
+	tokenTTLWarning = 15 * time.Minute
+)
+
+// Check is the result of a single preflight check
+type Check struct {
+	Target  string `json:"target"`
+	Name    string `json:"check"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// requirement is a set of capabilities needed on a path and the status when any are missing
+type requirement struct {
+	path   string
+	wanted []string
+	status string
+}
+
+// Doctor runs the preflight checks a sync depends on against both vaults
+func (v *Client) Doctor(appConfig *config.AppConfig) (checks []*Check) {
+	checks = append(checks, doctor("source", appConfig.Source, false)...)
+	if appConfig.Destination.Client == nil {
+		return append(checks, &Check{"destination", "configured", CheckWarn, "no destination vault address provided"})
+	}
+
+	return append(checks, doctor("destination", appConfig.Destination, true)...)
+}
+
+// Failed returns true when any of the checks failed
+func Failed(checks []*Check) bool {
+	for _, check := range checks {
+		if check.Status == CheckFail {
+			return true
+		}
+	}
+
+	return false
+}
+
+// doctor runs the preflight checks against a single vault
+func doctor(target string, service *config.VaultService, destinationVault bool) (checks []*Check) {
+	add := func(name, status, format string, a ...interface{}) {
+		checks = append(checks, &Check{target, name, status, fmt.Sprintf(format, a...)})
+	}
+	client := service.Client
+	log.Debugf("running preflight checks on %s vault %s", target, displayAddress(client))
+
+	// reachability and tls, nothing else can be checked without these
+	health, err := checkHealth(target, client)
+	if err != nil {
+		if isTLSError(err) {
+			add("tls", CheckFail, "%s", err)
+		} else {
+			add("reachable", CheckFail, "%s", err)
+		}
+		return checks
+	}
+	add("reachable", CheckPass, "%s", displayAddress(client))
+	checks = append(checks, tlsCheck(target, service))
+
+	// seal and standby status
+	switch {
+	case !health.Initialized:
+		add("sealed", CheckFail, "vault is not initialized")
+	case health.Sealed:
+		add("sealed", CheckFail, "vault is sealed")
+	default:
+		add("sealed", CheckPass, "unsealed")
+	}
+	switch {
+	case health.PerformanceStandby:
+		add("standby", CheckWarn, "performance standby, writes are forwarded to the active node")
+	case health.Standby:
+		add("standby", CheckWarn, "standby, requests are forwarded to the active node")
+	default:
+		add("standby", CheckPass, "active")
+	}
+	add("version", CheckPass, "%s", health.Version)
+	if health.Sealed || !health.Initialized {
+		return checks
+	}
+
+	// token validity and remaining ttl
+	token, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		add("token", CheckFail, "token lookup failed: %s", err)
+		return checks
+	}
+	ttl, _ := token.TokenTTL()
+	renewable, _ := token.TokenIsRenewable()
+	switch {
+	case ttl == 0:
+		add("token", CheckPass, "valid, does not expire")
+	case ttl < tokenTTLWarning && !renewable:
+		add("token", CheckWarn, "valid, expires in %s and is not renewable", ttl)
+	default:
+		add("token", CheckPass, "valid, expires in %s (renewable: %v)", ttl, renewable)
+	}
+
+	// mount existence and engine type
+	mounts, err := getMounts(client)
+	if err != nil {
+		add("mount", CheckFail, "unable to list mounts: %s", err)
+		return checks
+	}
+	mountPath, mount := mountOf(mounts, service.VaultEntrypoint)
+	if mount == nil {
+		add("mount", CheckFail, "no mount found for entrypoint %s", service.VaultEntrypoint)
+		return checks
+	}
+	kv2 := kvVersion(mount) == "2"
+	switch mount.Type {
+	case "kv", "generic":
+		add("mount", CheckPass, "%s is a %s engine (kv version %s)", mountPath, mount.Type, kvVersion(mount))
+	default:
+		add("mount", CheckFail, "%s is a %s engine, only kv engines can be sync'd", mountPath, mount.Type)
+		return checks
+	}
+
+	// capabilities on the entrypoint's data and metadata paths
+	rest := strings.Trim(strings.TrimPrefix(normalizeVaultPath("/"+service.VaultEntrypoint+"/"), "/"+mountPath), "/")
+	dataPath := strings.TrimSuffix(mountPath, "/") + "/" + rest
+	metadataPath := dataPath
+	if kv2 {
+		dataPath = strings.TrimSuffix(mountPath, "/") + "/data/" + rest
+		metadataPath = strings.TrimSuffix(mountPath, "/") + "/metadata/" + rest
+	}
+	dataPath = normalizeVaultPath(dataPath + "/" + doctorProbe)
+	metadataPath = normalizeVaultPath(metadataPath + "/")
+
+	capabilities := []requirement{
+		{dataPath, []string{"read"}, CheckFail},
+		{metadataPath, []string{"list"}, CheckFail},
+	}
+	if destinationVault {
+		capabilities[0].wanted = []string{"read", "create", "update"}
+		if kv2 {
+			// only needed to remove orphans
+			capabilities = append(capabilities, requirement{normalizeVaultPath(strings.TrimSuffix(metadataPath, "/") + "/" + doctorProbe), []string{"delete"}, CheckWarn})
+		}
+	}
+	for _, c := range capabilities {
+		checks = append(checks, capabilityCheck(target, client, c.path, c.wanted, c.status))
+	}
+
+	return checks
+}
+
+// capabilityCheck checks the token holds the capabilities on a path, with the status given when it does not
+func capabilityCheck(target string, client *api.Client, path string, wanted []string, missingStatus string) *Check {
+	check := &Check{Target: target, Name: "capabilities " + path}
+
+	held, err := client.Sys().CapabilitiesSelf(path)
+	if err != nil {
+		check.Status = CheckFail
+		check.Message = err.Error()
+		return check
+	}
+
+	var missing []string
+	for _, want := range wanted {
+		if !hasCapability(held, want) {
+			missing = append(missing, want)
+		}
+	}
+	if len(missing) > 0 {
+		check.Status = missingStatus
+		check.Message = fmt.Sprintf("missing %s (has %s)", strings.Join(missing, ", "), strings.Join(held, ", "))
+		return check
+	}
+	check.Status = CheckPass
+	check.Message = strings.Join(held, ", ")
+
+	return check
+}
+
+// hasCapability returns true when the capability is held, root holds all
+func hasCapability(held []string, capability string) bool {
+	for _, h := range held {
+		if h == "deny" {
+			return false
+		}
+		if h == capability || h == "root" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tlsCheck reports on the tls settings used to reach a vault
+func tlsCheck(target string, service *config.VaultService) *Check {
+	check := &Check{Target: target, Name: "tls", Status: CheckPass}
+
+	if service.Vault != nil && strings.HasPrefix(service.Vault.Address, "unix://") {
+		check.Message = "local unix socket"
+		return check
+	}
+	u, err := url.Parse(service.Client.Address())
+	if err == nil && u.Scheme != "https" {
+		check.Status = CheckWarn
+		check.Message = "connection is not encrypted"
+		return check
+	}
+	if service.Vault != nil && service.Vault.HttpClient != nil {
+		if transport, ok := service.Vault.HttpClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil && transport.TLSClientConfig.InsecureSkipVerify {
+			check.Status = CheckWarn
+			check.Message = "certificate verification is disabled"
+			return check
+		}
+	}
+	check.Message = "certificate verified"
+
+	return check
+}
+
+// isTLSError returns true when an error was caused by tls
+func isTLSError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	switch err.(type) {
+	case x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError:
+		return true
+	}
+
+	return strings.Contains(err.Error(), "x509:") || strings.Contains(err.Error(), "tls:")
+}
+
+// mountOf returns the longest mount matching a secret path
+func mountOf(mounts map[string]*api.MountOutput, secretPath string) (mountPath string, mount *api.MountOutput) {
+	secretPath = strings.TrimPrefix(normalizeVaultPath("/"+secretPath+"/"), "/")
+	for k, m := range mounts {
+		if strings.HasPrefix(secretPath, k) && len(k) > len(mountPath) {
+			mountPath, mount = k, m
+		}
+	}
+
+	return mountPath, mount
+}
+
+// kvVersion returns the kv version of a mount
+func kvVersion(mount *api.MountOutput) string {
+	if mount.Type == "generic" {
+		return "1"
+	}
+	if version, ok := mount.Options["version"]; ok {
+		return version
+	}
+
+	return "1"
+}
@@ -1,26 +1,181 @@
 package vault
 
 import (
-	"io/ioutil"
+	"fmt"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
 )
 
+// exit codes of the health check, the worst of all vaults checked is used
+const (
+	HealthCodeOK            = 0
+	HealthCodeUnreachable   = 2
+	HealthCodeUninitialized = 3
+	HealthCodeSealed        = 4
+	HealthCodeStandby       = 5
+)
+
+// healthSeverity ranks the exit codes from healthy to the worst, the codes
+// themselves are kept as they are for scripts checking them
+var healthSeverity = map[int]int{
+	HealthCodeOK:            0,
+	HealthCodeStandby:       1,
+	HealthCodeSealed:        2,
+	HealthCodeUninitialized: 3,
+	HealthCodeUnreachable:   4,
+}
+
+// Health is the parsed health status of a vault server
+type Health struct {
+	Target                     string `json:"target"`
+	Address                    string `json:"address"`
+	Reachable                  bool   `json:"reachable"`
+	Error                      string `json:"error,omitempty"`
+	Initialized                bool   `json:"initialized"`
+	Sealed                     bool   `json:"sealed"`
+	Standby                    bool   `json:"standby"`
+	PerformanceStandby         bool   `json:"performance_standby"`
+	Version                    string `json:"version,omitempty"`
+	ClusterName                string `json:"cluster_name,omitempty"`
+	ReplicationPerformanceMode string `json:"replication_performance_mode,omitempty"`
+	ReplicationDRMode          string `json:"replication_dr_mode,omitempty"`
+}
+
 // HealthCheck performs a health check on the vault server
-func (v *Client) HealthCheck(appConfig *config.AppConfig, destinationVault bool) (status string, err error) {
-	log.Info("checking health")
+func (v *Client) HealthCheck(appConfig *config.AppConfig, destinationVault bool) (*Health, error) {
+	target := "source"
+	if destinationVault {
+		target = "destination"
+	}
+	log.Debugf("checking health of %s vault", target)
 
-	result, err := v.Request(appConfig, destinationVault, "GET", "/sys/health", "")
-	if err != nil {
-		log.Error(err)
-		log.Fatalf("error getting health status: %s", err)
+	return checkHealth(target, getClient(appConfig, destinationVault))
+}
+
+// HealthCheckAll performs a health check on the source and, when configured,
+// destination vault servers at once
+func (v *Client) HealthCheckAll(appConfig *config.AppConfig) []*Health {
+	targets := []bool{false}
+	if appConfig.Destination.Client != nil {
+		targets = append(targets, true)
+	}
+
+	results := make([]*Health, len(targets))
+	var wg sync.WaitGroup
+	for i, destinationVault := range targets {
+		wg.Add(1)
+		go func(i int, destinationVault bool) {
+			defer wg.Done()
+			results[i], _ = v.HealthCheck(appConfig, destinationVault)
+		}(i, destinationVault)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// WaitHealthy blocks until every vault, or only the destination vault, is
+// initialized, unsealed and active, or the timeout passes, returning the last results
+func (v *Client) WaitHealthy(appConfig *config.AppConfig, destinationVault bool, timeout, interval time.Duration) ([]*Health, error) {
+	check := func() []*Health {
+		return v.HealthCheckAll(appConfig)
+	}
+	if destinationVault {
+		check = func() []*Health {
+			h, _ := v.HealthCheck(appConfig, true)
+			return []*Health{h}
+		}
 	}
 
-	body, err := ioutil.ReadAll(result.Body)
+	deadline := time.Now().Add(timeout)
+	for {
+		results := check()
+		code := HealthCode(results)
+		if code == HealthCodeOK {
+			return results, nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return results, fmt.Errorf("vaults not healthy after %s", timeout)
+		}
+		for _, h := range results {
+			if h.Code() != HealthCodeOK {
+				log.Infof("waiting for %s vault: %s %s", h.Target, h.Status(), h.Error)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// HealthCode returns the exit code of the worst of the results, an unreachable
+// vault being worse than one not initialized, sealed or on standby
+func HealthCode(results []*Health) (code int) {
+	for _, h := range results {
+		if c := h.Code(); healthSeverity[c] > healthSeverity[code] {
+			code = c
+		}
+	}
+
+	return code
+}
+
+// Code returns the exit code for the health status
+func (h *Health) Code() int {
+	switch {
+	case !h.Reachable:
+		return HealthCodeUnreachable
+	case !h.Initialized:
+		return HealthCodeUninitialized
+	case h.Sealed:
+		return HealthCodeSealed
+	case h.Standby || h.PerformanceStandby:
+		return HealthCodeStandby
+	}
+
+	return HealthCodeOK
+}
+
+// Status returns a short description of the health status
+func (h *Health) Status() string {
+	switch h.Code() {
+	case HealthCodeUnreachable:
+		return "unreachable"
+	case HealthCodeUninitialized:
+		return "not initialized"
+	case HealthCodeSealed:
+		return "sealed"
+	case HealthCodeStandby:
+		if h.PerformanceStandby {
+			return "performance standby"
+		}
+		return "standby"
+	}
+
+	return "active"
+}
+
+// checkHealth reads sys/health of a vault
+func checkHealth(target string, client *api.Client) (*Health, error) {
+	h := &Health{Target: target, Address: displayAddress(client)}
+
+	resp, err := client.Sys().Health()
 	if err != nil {
-		log.Fatalf("error reading http body: %s", err)
+		h.Error = err.Error()
+		return h, err
 	}
 
-	return string(body), err
+	h.Reachable = true
+	h.Initialized = resp.Initialized
+	h.Sealed = resp.Sealed
+	h.Standby = resp.Standby
+	h.PerformanceStandby = resp.PerformanceStandby
+	h.Version = resp.Version
+	h.ClusterName = resp.ClusterName
+	h.ReplicationPerformanceMode = resp.ReplicationPerformanceMode
+	h.ReplicationDRMode = resp.ReplicationDRMode
+
+	return h, nil
 }
@@ -0,0 +1,34 @@
+package vault
+
+import "testing"
+
+func TestHealthCode(t *testing.T) {
+	active := &Health{Reachable: true, Initialized: true}
+	standby := &Health{Reachable: true, Initialized: true, Standby: true}
+	performanceStandby := &Health{Reachable: true, Initialized: true, PerformanceStandby: true}
+	sealed := &Health{Reachable: true, Initialized: true, Sealed: true}
+	uninitialized := &Health{Reachable: true}
+	unreachable := &Health{}
+
+	tests := []struct {
+		name    string
+		results []*Health
+		want    int
+	}{
+		{name: "none", want: HealthCodeOK},
+		{name: "active", results: []*Health{active, active}, want: HealthCodeOK},
+		{name: "standby", results: []*Health{active, standby}, want: HealthCodeStandby},
+		{name: "performance standby", results: []*Health{performanceStandby, active}, want: HealthCodeStandby},
+		{name: "sealed over standby", results: []*Health{standby, sealed}, want: HealthCodeSealed},
+		{name: "uninitialized over sealed", results: []*Health{uninitialized, sealed}, want: HealthCodeUninitialized},
+		{name: "unreachable over standby", results: []*Health{unreachable, standby}, want: HealthCodeUnreachable},
+		{name: "unreachable over sealed", results: []*Health{sealed, unreachable}, want: HealthCodeUnreachable},
+		{name: "unreachable", results: []*Health{unreachable}, want: HealthCodeUnreachable},
+	}
+
+	for _, test := range tests {
+		if got := HealthCode(test.results); got != test.want {
+			t.Errorf("%s: HealthCode() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flaccid/vsync/config"
+)
+
+// ReadJobStore reads every job kept below the path of a job store, returning
+// the keys of each by its path below the store
+func ReadJobStore(service *config.VaultService, storePath string) (map[string]map[string]interface{}, error) {
+	storePath = config.CleanPath(storePath)
+	secretPaths, err := getSecretPaths(service.Client, storePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the jobs below %s: %s", storePath, err)
+	}
+
+	v := &Client{}
+	documents := make(map[string]map[string]interface{})
+	for _, secretPath := range secretPaths {
+		secret, err := v.ReadSecret(&config.AppConfig{Source: service}, secretPath, false)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the job %s: %s", secretPath, err)
+		}
+		documents[strings.TrimPrefix(config.CleanPath(secretPath), storePath+"/")] = secretData(secret)
+	}
+
+	return documents, nil
+}
@@ -0,0 +1,315 @@
+package vault
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// journal actions
+	JournalActionCreate = "create"
+	JournalActionUpdate = "update"
+	JournalActionDelete = "delete"
+
+	// journalVaultPrefix selects a kv path in the destination vault as the journal store
+	journalVaultPrefix = "vault:"
+)
+
+// JournalEntry is a single record of a change vsync made to a vault
+type JournalEntry struct {
+	Sequence        int       `json:"seq"`
+	Timestamp       time.Time `json:"timestamp"`
+	Job             string    `json:"job,omitempty"`
+	SourcePath      string    `json:"source_path,omitempty"`
+	DestinationPath string    `json:"destination_path"`
+	Action          string    `json:"action"`
+	PreviousVersion int       `json:"previous_version"`
+	NewVersion      int       `json:"new_version"`
+	KeysAdded       []string  `json:"keys_added,omitempty"`
+	KeysRemoved     []string  `json:"keys_removed,omitempty"`
+	KeysChanged     []string  `json:"keys_changed,omitempty"`
+	PreviousHMAC    string    `json:"previous_hmac"`
+	HMAC            string    `json:"hmac"`
+}
+
+// Journal is an append-only, hmac chained record of every change vsync makes
+type Journal struct {
+	key   []byte
+	store journalStore
+	last  *JournalEntry
+	mutex sync.Mutex
+}
+
+// journalStore is the storage backend of a journal
+type journalStore interface {
+	append(entry *JournalEntry) error
+	entries() ([]*JournalEntry, error)
+}
+
+// OpenJournal opens the journal at location, which is either a local file path
+// or a kv path in the destination vault prefixed with vault:
+func OpenJournal(location string, key string, destination *api.Client) (*Journal, error) {
+	if len(key) < 1 {
+		return nil, errors.New("a journal key is required to open the journal")
+	}
+
+	var store journalStore
+	if strings.HasPrefix(location, journalVaultPrefix) {
+		if destination == nil {
+			return nil, errors.New("a destination vault is required for a vault journal")
+		}
+		store = &vaultJournalStore{
+			client: destination,
+			path:   normalizeVaultPath("/" + strings.TrimPrefix(location, journalVaultPrefix)),
+		}
+	} else {
+		store = &fileJournalStore{path: location}
+	}
+
+	entries, err := store.entries()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read journal %s: %s", location, err)
+	}
+
+	journal := &Journal{key: []byte(key), store: store}
+	if len(entries) > 0 {
+		journal.last = entries[len(entries)-1]
+	}
+	log.Debugf("journal %s opened with %v entries", location, len(entries))
+
+	return journal, nil
+}
+
+// Record appends an entry to the journal, chaining it to the previous entry
+func (j *Journal) Record(entry *JournalEntry) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	entry.Sequence = 1
+	entry.PreviousHMAC = ""
+	if j.last != nil {
+		entry.Sequence = j.last.Sequence + 1
+		entry.PreviousHMAC = j.last.HMAC
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	entry.HMAC = ""
+
+	sum, err := j.sum(entry)
+	if err != nil {
+		return err
+	}
+	entry.HMAC = sum
+
+	if err := j.store.append(entry); err != nil {
+		return fmt.Errorf("unable to append to journal: %s", err)
+	}
+	j.last = entry
+
+	return nil
+}
+
+// Verify checks the hmac chain of every entry in the journal and returns
+// the number of entries and the hmac of the last entry
+func (j *Journal) Verify() (count int, head string, err error) {
+	entries, err := j.store.entries()
+	if err != nil {
+		return 0, "", err
+	}
+
+	previous := ""
+	for i, entry := range entries {
+		if entry.Sequence != i+1 {
+			return i, previous, fmt.Errorf("entry %v: expected sequence %v, found %v", i+1, i+1, entry.Sequence)
+		}
+		if entry.PreviousHMAC != previous {
+			return i, previous, fmt.Errorf("entry %v: chain broken, previous hmac does not match", entry.Sequence)
+		}
+
+		recorded := entry.HMAC
+		entry.HMAC = ""
+		sum, err := j.sum(entry)
+		entry.HMAC = recorded
+		if err != nil {
+			return i, previous, err
+		}
+		if !hmac.Equal([]byte(sum), []byte(recorded)) {
+			return i, previous, fmt.Errorf("entry %v: hmac mismatch, entry has been altered", entry.Sequence)
+		}
+		previous = recorded
+	}
+
+	return len(entries), previous, nil
+}
+
+// owns returns true when a secret path is one of the journal's own entries
+func (j *Journal) owns(secretPath string) bool {
+	if j == nil {
+		return false
+	}
+	store, ok := j.store.(*vaultJournalStore)
+	if !ok {
+		return false
+	}
+
+	return strings.HasPrefix(normalizeVaultPath(secretPath+"/"), store.path+"/")
+}
+
+// sum returns the hex encoded hmac of an entry
+func (j *Journal) sum(entry *JournalEntry) (string, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, j.key)
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// fileJournalStore stores journal entries as json lines in a local file
+type fileJournalStore struct {
+	path string
+}
+
+func (s *fileJournalStore) append(entry *JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func (s *fileJournalStore) entries() (entries []*JournalEntry, err error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) < 1 {
+			continue
+		}
+		entry := &JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("line %v: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// vaultJournalStore stores each journal entry as a secret under a kv path,
+// keyed by its zero padded sequence number
+type vaultJournalStore struct {
+	client *api.Client
+	path   string
+}
+
+func (s *vaultJournalStore) append(entry *JournalEntry) error {
+	data, err := journalEntryValues(entry)
+	if err != nil {
+		return err
+	}
+	_, err = writeSecret(s.client, fmt.Sprintf("%s/%010d", s.path, entry.Sequence), data)
+
+	return err
+}
+
+func (s *vaultJournalStore) entries() (entries []*JournalEntry, err error) {
+	listPath := s.path
+	kv2 := isKV2(s.client, s.path)
+	if kv2 {
+		listPath = kvPath(s.client, s.path, "metadata")
+	}
+
+	list, err := s.client.Logical().List(listPath)
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		return nil, nil
+	}
+
+	var keys []string
+	if k, ok := list.Data["keys"].([]interface{}); ok {
+		for _, key := range k {
+			keys = append(keys, key.(string))
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		readPath := s.path + "/" + key
+		if kv2 {
+			readPath = kvPath(s.client, readPath, "data")
+		}
+		secret, err := s.client.Logical().Read(readPath)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			return nil, fmt.Errorf("journal entry %s disappeared", key)
+		}
+		entry := &JournalEntry{}
+		b, err := json.Marshal(secretData(secret))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, entry); err != nil {
+			return nil, fmt.Errorf("journal entry %s: %s", key, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// journalEntryValues converts an entry into secret key/values
+func journalEntryValues(entry *JournalEntry) (values map[string]interface{}, err error) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &values)
+
+	return values, err
+}
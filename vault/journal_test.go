@@ -0,0 +1,122 @@
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJournalVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		tamper    func(lines []string) []string
+		wantCount int
+		wantErr   string
+	}{
+		{name: "intact", key: "key", wantCount: 3},
+		{name: "wrong key", key: "other", wantErr: "entry 1: hmac mismatch"},
+		{name: "altered entry", key: "key", wantCount: 1, wantErr: "entry 2: hmac mismatch", tamper: func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "/secret/b", "/secret/x", 1)
+			return lines
+		}},
+		{name: "removed entry", key: "key", wantCount: 1, wantErr: "entry 2: expected sequence 2, found 3", tamper: func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}},
+		// a truncated journal is only told apart by a head kept elsewhere
+		{name: "removed last entry", key: "key", wantCount: 2, tamper: func(lines []string) []string {
+			return lines[:2]
+		}},
+		{name: "reordered entries", key: "key", wantCount: 1, wantErr: "entry 2: expected sequence 2", tamper: func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "vsync-journal")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			defer os.Remove(f.Name())
+
+			journal, err := OpenJournal(f.Name(), "key", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range []*JournalEntry{
+				{DestinationPath: "/secret/a", Action: JournalActionCreate, KeysAdded: []string{"password"}},
+				{DestinationPath: "/secret/b", Action: JournalActionUpdate, KeysChanged: []string{"password"}},
+				{DestinationPath: "/secret/a", Action: JournalActionDelete, KeysRemoved: []string{"password"}},
+			} {
+				if err := journal.Record(entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.tamper != nil {
+				data, err := ioutil.ReadFile(f.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := test.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+				if err := ioutil.WriteFile(f.Name(), []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			journal, err = OpenJournal(f.Name(), test.key, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, head, err := journal.Verify()
+			if len(test.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Verify() error %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Verify() error %v", err)
+			}
+			if count != test.wantCount {
+				t.Errorf("Verify() verified %v entries, want %v", count, test.wantCount)
+			}
+			if err == nil && (len(head) != 64 || head != journal.last.HMAC) {
+				t.Errorf("Verify() head %q, want the hmac of the last entry", head)
+			}
+		})
+	}
+}
+
+func TestJournalRecordChains(t *testing.T) {
+	f, err := ioutil.TempFile("", "vsync-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	journal, err := OpenJournal(f.Name(), "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &JournalEntry{DestinationPath: "/secret/a", Action: JournalActionCreate}
+	if err := journal.Record(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// a reopened journal continues the chain
+	journal, err = OpenJournal(f.Name(), "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := &JournalEntry{DestinationPath: "/secret/a", Action: JournalActionUpdate}
+	if err := journal.Record(second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Sequence != 2 || second.PreviousHMAC != first.HMAC {
+		t.Errorf("second entry has sequence %v and previous hmac %q, want 2 and %q", second.Sequence, second.PreviousHMAC, first.HMAC)
+	}
+}
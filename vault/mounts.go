@@ -0,0 +1,89 @@
+package vault
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// mountCache keeps the mounts listed from each vault and namespace, see CacheMounts
+var mountCache = struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]*cachedMounts
+}{entries: make(map[string]*cachedMounts)}
+
+// cachedMounts are the mounts of a vault namespace and when they are listed again
+type cachedMounts struct {
+	mounts  map[string]*api.MountOutput
+	expires time.Time
+}
+
+// KVMount is a kv secrets engine mounted in a vault
+type KVMount struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+// KVMounts lists the kv secrets engines of a vault, sorted by path
+func KVMounts(client *api.Client) ([]*KVMount, error) {
+	mounts, err := getMounts(client)
+	if err != nil {
+		return nil, err
+	}
+
+	var kv []*KVMount
+	for mountPath, mount := range mounts {
+		if mount.Type == "kv" || mount.Type == "generic" {
+			kv = append(kv, &KVMount{Path: mountPath, Version: kvVersion(mount)})
+		}
+	}
+	sort.Slice(kv, func(i, j int) bool { return kv[i].Path < kv[j].Path })
+
+	return kv, nil
+}
+
+// CacheMounts keeps the mounts listed from each vault and namespace for the ttl
+// rather than listing them for every secret, e.g. across the runs of a daemon,
+// zero turns the cache off
+func CacheMounts(ttl time.Duration) {
+	mountCache.Lock()
+	defer mountCache.Unlock()
+
+	mountCache.ttl = ttl
+	mountCache.entries = make(map[string]*cachedMounts)
+}
+
+// mountCacheKey identifies the vault and namespace a client makes its requests to
+func mountCacheKey(v *api.Client) string {
+	return v.Address() + "|" + v.Headers().Get(namespaceHeader)
+}
+
+// cachedMountsOf returns the cached mounts of the client's vault namespace, nil when not cached
+func cachedMountsOf(v *api.Client) map[string]*api.MountOutput {
+	mountCache.Lock()
+	defer mountCache.Unlock()
+
+	if mountCache.ttl <= 0 {
+		return nil
+	}
+	entry, ok := mountCache.entries[mountCacheKey(v)]
+	if !ok || time.Now().After(entry.expires) {
+		return nil
+	}
+
+	return entry.mounts
+}
+
+// cacheMounts keeps the mounts of the client's vault namespace, when caching
+func cacheMounts(v *api.Client, mounts map[string]*api.MountOutput) {
+	mountCache.Lock()
+	defer mountCache.Unlock()
+
+	if mountCache.ttl <= 0 {
+		return
+	}
+	mountCache.entries[mountCacheKey(v)] = &cachedMounts{mounts: mounts, expires: time.Now().Add(mountCache.ttl)}
+}
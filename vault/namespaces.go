@@ -0,0 +1,175 @@
+package vault
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// namespaceHeader is the header vault enterprise reads the namespace of a request from
+const namespaceHeader = "X-Vault-Namespace"
+
+// ForEachNamespace calls fn with the app config for the source and destination
+// namespaces, then when recursive, once for each child namespace of the source
+// namespace with clients for it and the destination namespace it maps to
+func (v *Client) ForEachNamespace(appConfig *config.AppConfig, fn func(*config.AppConfig) error) error {
+	namespaces := []string{""}
+	if appConfig.RecursiveNamespaces {
+		children, err := childNamespaces(appConfig.Source.Client, appConfig.Source.Namespace, "")
+		if err != nil {
+			return err
+		}
+		namespaces = append(namespaces, children...)
+	}
+
+	for _, relative := range namespaces {
+		if v.Stopped() {
+			return nil
+		}
+		sourceNamespace := joinNamespace(appConfig.Source.Namespace, relative)
+		destinationNamespace := MapNamespace(appConfig.NamespaceMappings, sourceNamespace, joinNamespace(appConfig.Destination.Namespace, relative))
+		if len(relative) < 1 && destinationNamespace == strings.Trim(appConfig.Destination.Namespace, "/") {
+			if err := fn(appConfig); err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Infof("sync namespace %q to %q", sourceNamespace, destinationNamespace)
+		nsConfig, err := namespaceConfig(appConfig, sourceNamespace, destinationNamespace)
+		if err != nil {
+			return err
+		}
+		err = fn(nsConfig)
+		unfollow(nsConfig.Source.Client, nsConfig.Destination.Client)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MapNamespace returns the destination namespace of a source namespace using
+// the longest mapping that matches it or a parent of it, otherwise the default
+func MapNamespace(mappings []*config.NamespaceMapping, sourceNamespace, defaultNamespace string) string {
+	sourceNamespace = strings.Trim(sourceNamespace, "/")
+
+	var match *config.NamespaceMapping
+	for _, m := range mappings {
+		from := strings.Trim(m.Source, "/")
+		if (sourceNamespace == from || strings.HasPrefix(sourceNamespace, from+"/") || len(from) < 1) &&
+			(match == nil || len(from) > len(strings.Trim(match.Source, "/"))) {
+			match = m
+		}
+	}
+	if match == nil {
+		return strings.Trim(defaultNamespace, "/")
+	}
+
+	rest := strings.TrimPrefix(strings.TrimPrefix(sourceNamespace, strings.Trim(match.Source, "/")), "/")
+	return joinNamespace(match.Destination, rest)
+}
+
+// namespaceConfig returns a copy of the app config with clients in the namespaces
+func namespaceConfig(appConfig *config.AppConfig, sourceNamespace, destinationNamespace string) (*config.AppConfig, error) {
+	nsConfig := *appConfig
+	source := *appConfig.Source
+	destination := *appConfig.Destination
+	nsConfig.Source, nsConfig.Destination = &source, &destination
+
+	var err error
+	source.Namespace = sourceNamespace
+	source.Client, err = inNamespace(appConfig.Source.Client, sourceNamespace)
+	if err != nil {
+		return nil, err
+	}
+	destination.Namespace = destinationNamespace
+	if appConfig.Destination.Client != nil {
+		destination.Client, err = inNamespace(appConfig.Destination.Client, destinationNamespace)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &nsConfig, nil
+}
+
+// childNamespaces returns the namespaces below a namespace, recursively,
+// relative to the base namespace
+func childNamespaces(client *api.Client, base, relative string) (namespaces []string, err error) {
+	c, err := inNamespace(client, joinNamespace(base, relative))
+	if err != nil {
+		return nil, err
+	}
+	defer unfollow(c)
+
+	secret, err := c.Logical().List("sys/namespaces")
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, key := range keys {
+		child := joinNamespace(relative, key.(string))
+		log.Debugf("found namespace %s", joinNamespace(base, child))
+		namespaces = append(namespaces, child)
+
+		grandchildren, err := childNamespaces(client, base, child)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, grandchildren...)
+	}
+
+	return namespaces, nil
+}
+
+// inNamespace returns a copy of the client that makes every request in the
+// namespace, with the client's token even after it logs in again
+func inNamespace(client *api.Client, namespace string) (*api.Client, error) {
+	c, err := client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	follow(client, c)
+	c.SetHeaders(client.Headers())
+	setNamespace(c, namespace)
+
+	return c, nil
+}
+
+// setNamespace sets the namespace every request of the client is made in,
+// the root namespace when empty
+func setNamespace(client *api.Client, namespace string) {
+	headers := client.Headers()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	// the api client reads VAULT_NAMESPACE itself, which only belongs to the source
+	headers.Del(namespaceHeader)
+	if namespace = strings.Trim(namespace, "/"); len(namespace) > 0 {
+		headers.Set(namespaceHeader, namespace)
+	}
+	client.SetHeaders(headers)
+}
+
+// joinNamespace joins namespace paths
+func joinNamespace(namespaces ...string) string {
+	return strings.Trim(path.Join(namespaces...), "/")
+}
+
+// namespacedPath prefixes a secret path with the namespace it is in, for reports and logs
+func namespacedPath(service *config.VaultService, secretPath string) string {
+	if service == nil || len(strings.Trim(service.Namespace, "/")) < 1 {
+		return secretPath
+	}
+
+	return strings.Trim(service.Namespace, "/") + "/" + strings.TrimPrefix(secretPath, "/")
+}
@@ -0,0 +1,126 @@
+package vault
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flaccid/vsync/config"
+)
+
+func TestMapNamespace(t *testing.T) {
+	mappings := []*config.NamespaceMapping{
+		{Source: "admin", Destination: "dr/all"},
+		{Source: "admin/team-b", Destination: "dr/legacy/team-b"},
+		{Source: "/ops/", Destination: "/platform/"},
+	}
+
+	tests := []struct {
+		name             string
+		mappings         []*config.NamespaceMapping
+		sourceNamespace  string
+		defaultNamespace string
+		want             string
+	}{
+		{name: "no mappings", sourceNamespace: "admin/a", defaultNamespace: "dr/a", want: "dr/a"},
+		{name: "no match", mappings: mappings, sourceNamespace: "eng/a", defaultNamespace: "/dr/eng/a/", want: "dr/eng/a"},
+		{name: "exact", mappings: mappings, sourceNamespace: "admin/team-b", want: "dr/legacy/team-b"},
+		{name: "below", mappings: mappings, sourceNamespace: "admin/team-b/payments", want: "dr/legacy/team-b/payments"},
+		{name: "shorter mapping", mappings: mappings, sourceNamespace: "admin/team-c", want: "dr/all/team-c"},
+		{name: "segments only", mappings: mappings, sourceNamespace: "admin/team-bb", want: "dr/all/team-bb"},
+		{name: "slashes", mappings: mappings, sourceNamespace: "/ops/eu/", want: "platform/eu"},
+		{name: "root", mappings: []*config.NamespaceMapping{{Source: "", Destination: "dr"}}, sourceNamespace: "team-a", want: "dr/team-a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MapNamespace(test.mappings, test.sourceNamespace, test.defaultNamespace); got != test.want {
+				t.Errorf("MapNamespace(%q) = %q, want %q", test.sourceNamespace, got, test.want)
+			}
+		})
+	}
+}
+
+// namespaceHandler records each request with the namespace header it was made
+// in, answering as a vault with a kv v2 mount at secret/
+func namespaceHandler(mutex *sync.Mutex, requests map[string]bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.Method+" "+r.URL.Path+" in "+r.Header.Get(namespaceHeader)] = true
+		mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/mounts":
+			w.Write([]byte(`{"secret/": {"type": "kv", "options": {"version": "2"}}, "data": {"secret/": {"type": "kv", "options": {"version": "2"}}}}`))
+		case "/v1/secret/data/app":
+			w.Write([]byte(`{"data": {"data": {"key": "value"}, "metadata": {"version": 1}}}`))
+		case "/v1/secret/data/other":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}
+}
+
+func TestNamespaceHeader(t *testing.T) {
+	// the api client reads VAULT_NAMESPACE, which only belongs to the source
+	defer os.Setenv("VAULT_NAMESPACE", os.Getenv("VAULT_NAMESPACE"))
+	os.Setenv("VAULT_NAMESPACE", "from-env")
+
+	var mutex sync.Mutex
+	requests := make(map[string]bool)
+	server, service := stubVault(namespaceHandler(&mutex, requests))
+	defer server.Close()
+	service.Namespace = "/team-a/"
+	service.VaultToken = "token"
+
+	var err error
+	service.Client, err = Connect(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &Client{}
+	appConfig := &config.AppConfig{Source: service, Destination: service}
+	secret, err := v.ReadSecret(appConfig, "/secret/app", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secretData(secret)["key"] != "value" {
+		t.Errorf("read %v, want the secret's data", secretData(secret))
+	}
+	if _, err := writeSecret(service.Client, "/secret/other", map[string]interface{}{"key": "value"}); err != nil {
+		t.Fatal(err)
+	}
+
+	nsClient, err := inNamespace(service.Client, "team-a/child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unfollow(nsClient)
+	if _, err := nsClient.Sys().ListMounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GET /v1/sys/mounts in team-a",
+		"GET /v1/secret/data/app in team-a",
+		"PUT /v1/secret/data/other in team-a",
+		"GET /v1/sys/mounts in team-a/child",
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, request := range want {
+		if !requests[request] {
+			t.Errorf("no request %s, got %v", request, requests)
+		}
+	}
+	for request := range requests {
+		if strings.HasSuffix(request, " in from-env") || strings.HasSuffix(request, " in ") {
+			t.Errorf("request %s outside the namespace", request)
+		}
+	}
+}
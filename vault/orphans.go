@@ -1,10 +1,11 @@
 package vault
 
 import (
-	"strings"
+	"errors"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
 )
 
 // RemoveOprhans removes secret paths in the destination vault that no longer exist in the source vault
@@ -13,33 +14,70 @@ func (v *Client) RemoveOrphans(appConfig *config.AppConfig, path string) (secret
 
 	var orphans []string
 	secretPaths, err = getSecretPaths(appConfig.Destination.Client, path)
+	if err != nil {
+		return nil, err
+	}
 
 	// for each secret found in the destination,
 	// see if it exists in the source and remove if not found
 	for _, secretPath := range secretPaths {
-		secret, err := v.ReadSecret(appConfig, secretPath, false)
-		if err != nil {
-			log.Error(err)
+		if v.Stopped() {
+			return nil, nil
+		}
+		if v.Journal.owns(secretPath) {
+			continue
+		}
+		// secrets not sync'd from the source are left alone
+		source := sourcePath(appConfig, secretPath)
+		if !inScope(appConfig, source) {
+			continue
+		}
+		secret, err := v.ReadSecret(appConfig, source, false)
+		if errors.Is(err, ErrNotFound) {
 			orphans = append(orphans, secretPath)
+		} else if err != nil {
+			// a secret that can not be read from the source may still be there
+			log.Errorf("unable to read %s from the source, not removing %s: %s", source, secretPath, err)
+			v.Report.Fail(namespacedPath(appConfig.Destination, secretPath), err)
 		} else {
 			if secret.Data != nil {
-				log.Debugf("%s: ", secretPath, secret)
+				log.Debugf("%s: %v", secretPath, secret)
 			}
 		}
 	}
 
 	secretPaths = orphans
-	log.Debugf("secrets to remove: ", secretPaths)
+	log.Debugf("secrets to remove: %v", secretPaths)
 
 	// remove the orphans
 	for _, orphan := range orphans {
+		if v.Stopped() {
+			break
+		}
 		if appConfig.DryRun != true {
 			log.Info("remove " + orphan)
-			// assume kv2, deletes metadata and all versions
-			err := v.DeleteSecret(appConfig, strings.Replace(orphan, "/secret", "/secret/metadata", 1), true)
+			// read the orphan first so the journal can record what was removed
+			var previous *api.Secret
+			if v.Journal != nil {
+				previous, _ = v.ReadSecret(appConfig, orphan, true)
+			}
+			// for kv2, deletes metadata and all versions
+			deletePath := orphan
+			if isKV2(appConfig.Destination.Client, orphan) {
+				deletePath = kvPath(appConfig.Destination.Client, orphan, "metadata")
+			}
+			err := v.DeleteSecret(appConfig, deletePath, true)
 			if err != nil {
 				log.Errorf("failed to delete secret: %s", err)
+				v.Report.Fail(namespacedPath(appConfig.Destination, orphan), err)
+				continue
+			}
+			if err := v.recordDelete(appConfig, orphan, previous); err != nil {
+				log.Error(err)
+				v.Report.Fail(namespacedPath(appConfig.Destination, orphan), err)
+				continue
 			}
+			v.Report.Remove(namespacedPath(appConfig.Destination, orphan))
 		} else {
 			log.Infof("dry run, skipping actual removal of %s", orphan)
 		}
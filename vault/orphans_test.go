@@ -0,0 +1,284 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/flaccid/vsync/config"
+)
+
+// kvResponses answers requests to a stand-in vault with a kv mount at secret/,
+// version 2 unless kvVersion is given, by method and path, recording every
+// request made and the last body of each
+type kvResponses struct {
+	mutex     sync.Mutex
+	kvVersion string
+	responses map[string]string
+	statuses  map[string]int
+	requests  []string
+	bodies    map[string]map[string]interface{}
+}
+
+func (k *kvResponses) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := r.Method + " " + r.URL.Path
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	k.mutex.Lock()
+	k.requests = append(k.requests, request)
+	if k.bodies == nil {
+		k.bodies = make(map[string]map[string]interface{})
+	}
+	k.bodies[request] = body
+	k.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/v1/sys/mounts" {
+		version := k.kvVersion
+		if len(version) < 1 {
+			version = "2"
+		}
+		w.Write([]byte(`{"data": {"secret/": {"type": "kv", "options": {"version": "` + version + `"}}}}`))
+		return
+	}
+	status, ok := k.statuses[request]
+	if !ok {
+		status = http.StatusOK
+		if _, ok := k.responses[request]; !ok {
+			status = http.StatusNotFound
+		}
+	}
+	w.WriteHeader(status)
+	if body, ok := k.responses[request]; ok {
+		w.Write([]byte(body))
+	} else {
+		w.Write([]byte(`{"errors": []}`))
+	}
+}
+
+// made returns true when the request was made
+func (k *kvResponses) made(request string) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	for _, r := range k.requests {
+		if r == request {
+			return true
+		}
+	}
+
+	return false
+}
+
+// body returns the last body of the request
+func (k *kvResponses) body(request string) map[string]interface{} {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.bodies[request]
+}
+
+// stubKVs starts stand-in source and destination vaults, returning an app config for them
+func stubKVs(t *testing.T, source, destination *kvResponses) (*config.AppConfig, func()) {
+	sourceServer, sourceService := stubVault(source.ServeHTTP)
+	destinationServer, destinationService := stubVault(destination.ServeHTTP)
+	closeAll := func() {
+		sourceServer.Close()
+		destinationServer.Close()
+	}
+
+	var err error
+	for _, service := range []*config.VaultService{sourceService, destinationService} {
+		service.VaultToken = "token"
+		service.VaultEntrypoint = "/secret/apps"
+		if service.Client, err = Connect(service); err != nil {
+			closeAll()
+			t.Fatal(err)
+		}
+	}
+
+	return &config.AppConfig{Source: sourceService, Destination: destinationService}, closeAll
+}
+
+func TestRemoveOrphans(t *testing.T) {
+	source := &kvResponses{
+		responses: map[string]string{
+			"GET /v1/secret/data/apps/kept": `{"data": {"data": {"key": "value"}, "metadata": {"version": 1}}}`,
+		},
+		statuses: map[string]int{
+			"GET /v1/secret/data/apps/forbidden": http.StatusForbidden,
+			"GET /v1/secret/data/apps/sealed":    http.StatusServiceUnavailable,
+		},
+	}
+	destination := &kvResponses{
+		responses: map[string]string{
+			// the api client lists with a get
+			"GET /v1/secret/metadata/apps": `{"data": {"keys": ["forbidden", "gone", "kept", "sealed"]}}`,
+		},
+		statuses: map[string]int{
+			"DELETE /v1/secret/metadata/apps/gone": http.StatusNoContent,
+		},
+	}
+	appConfig, closeAll := stubKVs(t, source, destination)
+	defer closeAll()
+
+	v := &Client{Report: NewReport("", false)}
+	orphans, err := v.RemoveOrphans(appConfig, "/secret/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orphans) != 1 || orphans[0] != "/secret/apps/gone" {
+		t.Errorf("orphans %v, want only the secret the source does not have", orphans)
+	}
+	for _, secret := range []string{"forbidden", "kept", "sealed"} {
+		if destination.made("DELETE /v1/secret/metadata/apps/" + secret) {
+			t.Errorf("%s was removed", secret)
+		}
+	}
+	if !destination.made("DELETE /v1/secret/metadata/apps/gone") {
+		t.Error("the orphan was not removed")
+	}
+	if len(v.Report.Removed) != 1 || len(v.Report.Failed) != 2 {
+		t.Errorf("reported %v removed and %v failed, want 1 and the 2 unreadable secrets", v.Report.Removed, v.Report.Failed)
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	source := &kvResponses{responses: map[string]string{
+		"GET /v1/secret/data/apps/db": `{"data": {"data": {"password": "new"}, "metadata": {"version": 2}}}`,
+	}}
+	destination := &kvResponses{responses: map[string]string{
+		"GET /v1/secret/data/apps/db": `{"data": {"data": {"password": "old"}, "metadata": {"version": 1}}}`,
+	}}
+	appConfig, closeAll := stubKVs(t, source, destination)
+	defer closeAll()
+	appConfig.DryRun = true
+
+	v := &Client{Report: NewReport("", true)}
+	if err := v.SyncSecret(appConfig, "/secret/apps/db"); err != nil {
+		t.Fatal(err)
+	}
+	if destination.made("PUT /v1/secret/data/apps/db") || destination.made("POST /v1/secret/data/apps/db") {
+		t.Error("a dry run wrote the secret")
+	}
+	if len(v.Report.Changed) != 1 {
+		t.Errorf("reported %v changed, want the secret that would change", v.Report.Changed)
+	}
+}
+
+func TestSyncSecrets(t *testing.T) {
+	source := &kvResponses{responses: map[string]string{
+		"GET /v1/secret/metadata/apps":      `{"data": {"keys": ["db", "team/"]}}`,
+		"GET /v1/secret/metadata/apps/team": `{"data": {"keys": ["api"]}}`,
+		"GET /v1/secret/data/apps/db":       `{"data": {"data": {"password": "new"}, "metadata": {"version": 1}}}`,
+		"GET /v1/secret/data/apps/team/api": `{"data": {"data": {"key": "value"}, "metadata": {"version": 1}}}`,
+	}}
+	destination := &kvResponses{responses: map[string]string{
+		"PUT /v1/secret/data/apps/db":       `{"data": {"version": 1}}`,
+		"PUT /v1/secret/data/apps/team/api": `{"data": {"version": 1}}`,
+	}}
+	appConfig, closeAll := stubKVs(t, source, destination)
+	defer closeAll()
+
+	v := &Client{Report: NewReport("", false)}
+	v.SyncSecrets(appConfig)
+
+	for _, secret := range []string{"db", "team/api"} {
+		if !destination.made("PUT /v1/secret/data/apps/" + secret) {
+			t.Errorf("%s was not written to the destination", secret)
+		}
+	}
+	if len(v.Report.Changed) != 2 || len(v.Report.Failed) != 0 {
+		t.Errorf("reported %v changed and %v failed, want both secrets changed", v.Report.Changed, v.Report.Failed)
+	}
+}
+
+func TestConcurrentJobs(t *testing.T) {
+	var jobs []*config.AppConfig
+	var destinations []*kvResponses
+	for _, secret := range []string{"a", "b"} {
+		destination := &kvResponses{
+			responses: map[string]string{
+				"GET /v1/secret/metadata/apps": `{"data": {"keys": ["` + secret + `"]}}`,
+			},
+			statuses: map[string]int{
+				"DELETE /v1/secret/metadata/apps/" + secret: http.StatusNoContent,
+				"PUT /v1/secret/data/apps/written":          http.StatusNoContent,
+			},
+		}
+		appConfig, closeAll := stubKVs(t, &kvResponses{}, destination)
+		defer closeAll()
+		jobs, destinations = append(jobs, appConfig), append(destinations, destination)
+	}
+
+	// each job removes its orphan and writes a secret in its own destination
+	var wg sync.WaitGroup
+	for _, appConfig := range jobs {
+		wg.Add(1)
+		go func(appConfig *config.AppConfig) {
+			defer wg.Done()
+			v := &Client{Report: NewReport("", false)}
+			for i := 0; i < 20; i++ {
+				if _, err := v.RemoveOrphans(appConfig, "/secret/apps"); err != nil {
+					t.Error(err)
+				}
+				if err := v.WriteSecret(appConfig, &Secret{Path: "/secret/apps/written", Values: map[string]interface{}{"key": i}}, true); err != nil {
+					t.Error(err)
+				}
+			}
+		}(appConfig)
+	}
+	wg.Wait()
+
+	for i, destination := range destinations {
+		destination.mutex.Lock()
+		for _, request := range destination.requests {
+			if request == "DELETE /v1/secret/metadata/apps/a" && i != 0 || request == "DELETE /v1/secret/metadata/apps/b" && i != 1 {
+				t.Errorf("destination %v received %s of the other job", i, request)
+			}
+		}
+		destination.mutex.Unlock()
+	}
+}
+
+// unwritableJournal is a journal store that fails to append, such as a vault
+// journal while the vault is unavailable
+type unwritableJournal struct{}
+
+func (unwritableJournal) append(entry *JournalEntry) error {
+	return errors.New("vault unavailable")
+}
+
+func (unwritableJournal) entries() ([]*JournalEntry, error) {
+	return nil, nil
+}
+
+func TestSyncStopsWhenNotJournaled(t *testing.T) {
+	source := &kvResponses{responses: map[string]string{
+		"GET /v1/secret/metadata/apps": `{"data": {"keys": ["a", "b"]}}`,
+		"GET /v1/secret/data/apps/a":   `{"data": {"data": {"key": "a"}, "metadata": {"version": 1}}}`,
+		"GET /v1/secret/data/apps/b":   `{"data": {"data": {"key": "b"}, "metadata": {"version": 1}}}`,
+	}}
+	destination := &kvResponses{statuses: map[string]int{
+		"PUT /v1/secret/data/apps/a": http.StatusNoContent,
+		"PUT /v1/secret/data/apps/b": http.StatusNoContent,
+	}}
+	appConfig, closeAll := stubKVs(t, source, destination)
+	defer closeAll()
+
+	v := &Client{Report: NewReport("", false), Journal: &Journal{key: []byte("key"), store: unwritableJournal{}}}
+	v.SyncSecrets(appConfig)
+
+	if destination.made("PUT /v1/secret/data/apps/b") {
+		t.Error("a change was made after one could not be journaled")
+	}
+	if v.JournalErr() == nil || !v.Stopped() {
+		t.Errorf("JournalErr() = %v, want the run stopped by the journal error", v.JournalErr())
+	}
+	if len(v.Report.Failed) != 1 || len(v.Report.Changed) != 0 {
+		t.Errorf("reported %v failed and %v changed, want the secret not journaled failed", v.Report.Failed, v.Report.Changed)
+	}
+}
@@ -0,0 +1,81 @@
+package vault
+
+import (
+	"strings"
+
+	"github.com/flaccid/vsync/config"
+)
+
+// destinationPath returns the path a source secret is sync'd to: the longest
+// mapping that matches it or a parent of it, otherwise the same path below the
+// destination entrypoint as it is below the source entrypoint
+func destinationPath(appConfig *config.AppConfig, sourcePath string) string {
+	var match *config.PathMapping
+	for _, m := range appConfig.PathMappings {
+		if config.UnderPath(sourcePath, m.Source) && (match == nil || len(config.CleanPath(m.Source)) > len(config.CleanPath(match.Source))) {
+			match = m
+		}
+	}
+	if match != nil {
+		return rebasePath(sourcePath, match.Source, match.Destination)
+	}
+	if movedEntrypoint(appConfig) && config.UnderPath(sourcePath, appConfig.Source.VaultEntrypoint) {
+		return rebasePath(sourcePath, appConfig.Source.VaultEntrypoint, appConfig.Destination.VaultEntrypoint)
+	}
+
+	return sourcePath
+}
+
+// sourcePath returns the source path a destination secret is sync'd from, the reverse of destinationPath
+func sourcePath(appConfig *config.AppConfig, destinationPath string) string {
+	var match *config.PathMapping
+	for _, m := range appConfig.PathMappings {
+		if config.UnderPath(destinationPath, m.Destination) && (match == nil || len(config.CleanPath(m.Destination)) > len(config.CleanPath(match.Destination))) {
+			match = m
+		}
+	}
+	if match != nil {
+		return rebasePath(destinationPath, match.Destination, match.Source)
+	}
+	if movedEntrypoint(appConfig) && config.UnderPath(destinationPath, appConfig.Destination.VaultEntrypoint) {
+		return rebasePath(destinationPath, appConfig.Destination.VaultEntrypoint, appConfig.Source.VaultEntrypoint)
+	}
+
+	return destinationPath
+}
+
+// movedEntrypoint returns true when secrets are sync'd to a different entrypoint in the destination
+func movedEntrypoint(appConfig *config.AppConfig) bool {
+	if appConfig.Destination == nil || len(appConfig.Source.VaultEntrypoint) < 1 || len(appConfig.Destination.VaultEntrypoint) < 1 {
+		return false
+	}
+
+	return config.CleanPath(appConfig.Source.VaultEntrypoint) != config.CleanPath(appConfig.Destination.VaultEntrypoint)
+}
+
+// inScope returns true when a source path matches an include pattern, or
+// there are none, and does not match an exclude pattern
+func inScope(appConfig *config.AppConfig, secretPath string) bool {
+	for _, pattern := range appConfig.Exclude {
+		if config.MatchPath(pattern, secretPath) {
+			return false
+		}
+	}
+	if len(appConfig.Include) < 1 {
+		return true
+	}
+	for _, pattern := range appConfig.Include {
+		if config.MatchPath(pattern, secretPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rebasePath moves a secret path from below one parent path to below another
+func rebasePath(secretPath, from, to string) string {
+	rest := strings.TrimPrefix(config.CleanPath(secretPath), config.CleanPath(from))
+
+	return config.CleanPath(config.CleanPath(to) + "/" + rest)
+}
@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/flaccid/vsync/config"
+)
+
+// pathsConfig returns an app config syncing between the entrypoints with the mappings
+func pathsConfig(sourceEntrypoint, destinationEntrypoint string, mappings ...*config.PathMapping) *config.AppConfig {
+	return &config.AppConfig{
+		Source:       &config.VaultService{VaultEntrypoint: sourceEntrypoint},
+		Destination:  &config.VaultService{VaultEntrypoint: destinationEntrypoint},
+		PathMappings: mappings,
+	}
+}
+
+func TestDestinationPath(t *testing.T) {
+	mappings := []*config.PathMapping{
+		{Source: "/secret/payments/legacy", Destination: "/secret/payments/v1"},
+		{Source: "/secret/payments/legacy/db", Destination: "/kv/db"},
+	}
+
+	tests := []struct {
+		name            string
+		appConfig       *config.AppConfig
+		sourcePath      string
+		destinationPath string
+	}{
+		{name: "same entrypoint", appConfig: pathsConfig("/secret", "/secret"), sourcePath: "/secret/app/db", destinationPath: "/secret/app/db"},
+		{name: "no destination entrypoint", appConfig: pathsConfig("/secret", ""), sourcePath: "/secret/app/db", destinationPath: "/secret/app/db"},
+		{name: "moved entrypoint", appConfig: pathsConfig("/secret/payments", "/kv/apps/payments/"), sourcePath: "/secret/payments/db", destinationPath: "/kv/apps/payments/db"},
+		{name: "the entrypoint", appConfig: pathsConfig("/secret/payments", "/kv/payments"), sourcePath: "/secret/payments", destinationPath: "/kv/payments"},
+		{name: "mapping", appConfig: pathsConfig("/secret/payments", "/secret/payments", mappings...), sourcePath: "/secret/payments/legacy/api", destinationPath: "/secret/payments/v1/api"},
+		{name: "longest mapping", appConfig: pathsConfig("/secret/payments", "/secret/payments", mappings...), sourcePath: "/secret/payments/legacy/db/primary", destinationPath: "/kv/db/primary"},
+		{name: "mapping over entrypoint", appConfig: pathsConfig("/secret/payments", "/kv/payments", mappings...), sourcePath: "/secret/payments/legacy/api", destinationPath: "/secret/payments/v1/api"},
+		{name: "segments only", appConfig: pathsConfig("/secret/payments", "/kv/payments", mappings...), sourcePath: "/secret/payments/legacy-api", destinationPath: "/kv/payments/legacy-api"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := destinationPath(test.appConfig, test.sourcePath); got != test.destinationPath {
+				t.Errorf("destinationPath(%s) = %s, want %s", test.sourcePath, got, test.destinationPath)
+			}
+			// orphans are found by mapping the destination back to the source
+			if got := sourcePath(test.appConfig, test.destinationPath); got != test.sourcePath {
+				t.Errorf("sourcePath(%s) = %s, want %s", test.destinationPath, got, test.sourcePath)
+			}
+		})
+	}
+}
+
+func TestInScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		include    []string
+		exclude    []string
+		secretPath string
+		want       bool
+	}{
+		{name: "no patterns", secretPath: "/secret/app/db", want: true},
+		{name: "included", include: []string{"/secret/app"}, secretPath: "/secret/app/db", want: true},
+		{name: "not included", include: []string{"/secret/app"}, secretPath: "/secret/other/db", want: false},
+		{name: "segment glob", include: []string{"/secret/*/db"}, secretPath: "/secret/app/db/primary", want: true},
+		{name: "glob within a segment", include: []string{"/secret/*/db"}, secretPath: "/secret/app/api/db", want: false},
+		{name: "excluded", exclude: []string{"/secret/app/scratch"}, secretPath: "/secret/app/scratch/tmp", want: false},
+		{name: "excluded over included", include: []string{"/secret/app"}, exclude: []string{"/secret/app/scratch"}, secretPath: "/secret/app/scratch", want: false},
+		{name: "not excluded", exclude: []string{"/secret/app/scratch"}, secretPath: "/secret/app/scratchpad", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			appConfig := &config.AppConfig{Include: test.include, Exclude: test.exclude}
+			if got := inScope(appConfig, test.secretPath); got != test.want {
+				t.Errorf("inScope(%s) = %v, want %v", test.secretPath, got, test.want)
+			}
+		})
+	}
+}
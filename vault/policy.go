@@ -0,0 +1,197 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flaccid/vsync/config"
+)
+
+// capabilityOrder is the order capabilities are written in a policy
+var capabilityOrder = []string{"create", "read", "update", "delete", "list"}
+
+// Policy is the least privilege acl policy for what vsync does in a vault, built
+// up from the secret paths it reads and writes there
+type Policy struct {
+	Name    string
+	Service *config.VaultService
+
+	rules      map[string]*policyRule
+	namespaces bool
+}
+
+// policyRule is the capabilities granted on a path and what vsync needs them for
+type policyRule struct {
+	capabilities map[string]bool
+	reasons      map[string]bool
+}
+
+// NewPolicy returns an empty policy for the vault of a service, its client is
+// used to find the kv version of the paths added
+func NewPolicy(name string, service *config.VaultService) *Policy {
+	return &Policy{Name: name, Service: service, rules: make(map[string]*policyRule)}
+}
+
+// Read grants reading and listing the secrets below a path, e.g. a source entrypoint
+func (p *Policy) Read(secretPath, reason string) error {
+	data, metadata, _, err := p.kvPaths(secretPath)
+	if err != nil {
+		return err
+	}
+	p.grant(metadata, reason, "read", "list")
+	p.grant(data, reason, "read")
+
+	return nil
+}
+
+// Write grants writing the secrets below a path, reading them to compare, and
+// for orphans, listing them or also removing them
+func (p *Policy) Write(secretPath, reason, orphans string) error {
+	data, metadata, _, err := p.kvPaths(secretPath)
+	if err != nil {
+		return err
+	}
+	p.grant(data, reason, "create", "read", "update")
+	switch orphans {
+	case config.OrphansReport:
+		p.grant(metadata, reason+" orphans", "list")
+	case config.OrphansRemove:
+		p.grant(metadata, reason+" orphans", "list", "delete")
+	}
+
+	return nil
+}
+
+// Append grants adding secrets below a path and reading back the ones there, e.g. a journal
+func (p *Policy) Append(secretPath, reason string) error {
+	data, metadata, _, err := p.kvPaths(secretPath)
+	if err != nil {
+		return err
+	}
+	p.grant(metadata, reason, "list")
+	p.grant(data, reason, "create", "read")
+
+	return nil
+}
+
+// Annotate grants updating the custom metadata of the secrets below a kv v2 path
+func (p *Policy) Annotate(secretPath, reason string) error {
+	_, metadata, kv2, err := p.kvPaths(secretPath)
+	if err != nil {
+		return err
+	}
+	if kv2 {
+		p.grant(metadata, reason, "create", "read", "update")
+	}
+
+	return nil
+}
+
+// Namespaces grants listing the child namespaces, to sync recursively, the policy
+// is needed with the same name in each of the namespaces too
+func (p *Policy) Namespaces(reason string) {
+	p.namespaces = true
+	p.grant([]string{"sys/namespaces/*"}, reason, "list")
+}
+
+// HCL returns the policy in vault's acl format, with what each path is needed for
+func (p *Policy) HCL() string {
+	paths := make([]string, 0, len(p.rules))
+	for rulePath := range p.rules {
+		paths = append(paths, rulePath)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s, generated by vsync policy generate\n", p.Name)
+	if p.namespaces {
+		fmt.Fprintln(&buf, "# syncs namespaces recursively, create the policy in each of them too")
+	}
+	for _, rulePath := range paths {
+		rule := p.rules[rulePath]
+		var capabilities []string
+		for _, capability := range capabilityOrder {
+			if rule.capabilities[capability] {
+				capabilities = append(capabilities, fmt.Sprintf("%q", capability))
+			}
+		}
+
+		fmt.Fprintf(&buf, "\n# %s\n", strings.Join(sortedSet(rule.reasons), ", "))
+		fmt.Fprintf(&buf, "path %q {\n  capabilities = [%s]\n}\n", rulePath, strings.Join(capabilities, ", "))
+	}
+
+	return buf.String()
+}
+
+// Apply writes the policy to its vault
+func (p *Policy) Apply() error {
+	_, err := p.Service.Client.Logical().Write("sys/policies/acl/"+p.Name, map[string]interface{}{
+		"policy": p.HCL(),
+	})
+
+	return err
+}
+
+// kvPaths returns the acl paths of the data and metadata of the secrets at and
+// below a secret path, the same paths for kv v1, and grants reading the mounts
+// vsync looks the kv version up in
+func (p *Policy) kvPaths(secretPath string) (data, metadata []string, kv2 bool, err error) {
+	mounts, err := getMounts(p.Service.Client)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("unable to list the mounts: %s", err)
+	}
+	mountPath, mount := mountOf(mounts, secretPath)
+	if mount == nil {
+		return nil, nil, false, fmt.Errorf("no mount found for %s", secretPath)
+	}
+	if mount.Type != "kv" && mount.Type != "generic" {
+		return nil, nil, false, fmt.Errorf("%s is a %s engine, only kv engines can be sync'd", mountPath, mount.Type)
+	}
+	p.grant([]string{"sys/mounts"}, "kv versions of the mounts", "read")
+
+	mountPath = strings.TrimSuffix(mountPath, "/")
+	rest := strings.Trim(strings.TrimPrefix(config.CleanPath(secretPath), "/"+mountPath), "/")
+	// the same kv version the secrets are read and written by
+	if kvVersion(mount) == "2" {
+		return treePaths(mountPath+"/data", rest), treePaths(mountPath+"/metadata", rest), true, nil
+	}
+
+	return treePaths(mountPath, rest), treePaths(mountPath, rest), false, nil
+}
+
+// grant adds capabilities on paths to the policy
+func (p *Policy) grant(paths []string, reason string, capabilities ...string) {
+	for _, rulePath := range paths {
+		rule, ok := p.rules[rulePath]
+		if !ok {
+			rule = &policyRule{capabilities: make(map[string]bool), reasons: make(map[string]bool)}
+			p.rules[rulePath] = rule
+		}
+		for _, capability := range capabilities {
+			rule.capabilities[capability] = true
+		}
+		rule.reasons[reason] = true
+	}
+}
+
+// treePaths returns the acl paths of a secret and everything below it
+func treePaths(base, rest string) []string {
+	if len(rest) < 1 {
+		return []string{base + "/*"}
+	}
+
+	return []string{base + "/" + rest, base + "/" + rest + "/*"}
+}
+
+// sortedSet returns the members of a set, sorted
+func sortedSet(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	return members
+}
@@ -0,0 +1,221 @@
+package vault
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/flaccid/vsync/config"
+)
+
+// testPolicy returns a policy for a stand-in vault with a kv mount of the version at secret/
+func testPolicy(t *testing.T, kvVersion string) (*Policy, func()) {
+	server, service := stubVault((&kvResponses{kvVersion: kvVersion}).ServeHTTP)
+	service.VaultToken = "token"
+	var err error
+	if service.Client, err = Connect(service); err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return NewPolicy("vsync", service), server.Close
+}
+
+func TestPolicyPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		kvVersion string
+		grant     func(p *Policy) error
+		want      map[string]string
+	}{
+		{
+			name:      "read kv v2",
+			kvVersion: "2",
+			grant:     func(p *Policy) error { return p.Read("/secret/apps", "source") },
+			want: map[string]string{
+				"secret/data/apps":       "read",
+				"secret/data/apps/*":     "read",
+				"secret/metadata/apps":   "read list",
+				"secret/metadata/apps/*": "read list",
+				"sys/mounts":             "read",
+			},
+		},
+		{
+			name:      "read kv v1",
+			kvVersion: "1",
+			grant:     func(p *Policy) error { return p.Read("/secret/apps", "source") },
+			want: map[string]string{
+				"secret/apps":   "read list",
+				"secret/apps/*": "read list",
+				"sys/mounts":    "read",
+			},
+		},
+		{
+			name:      "read the mount",
+			kvVersion: "2",
+			grant:     func(p *Policy) error { return p.Read("/secret", "source") },
+			want: map[string]string{
+				"secret/data/*":     "read",
+				"secret/metadata/*": "read list",
+				"sys/mounts":        "read",
+			},
+		},
+		{
+			name:      "write kv v2 keeping orphans",
+			kvVersion: "2",
+			grant:     func(p *Policy) error { return p.Write("/secret/apps", "destination", config.OrphansKeep) },
+			want: map[string]string{
+				"secret/data/apps":   "create read update",
+				"secret/data/apps/*": "create read update",
+				"sys/mounts":         "read",
+			},
+		},
+		{
+			name:      "write kv v2 reporting orphans",
+			kvVersion: "2",
+			grant:     func(p *Policy) error { return p.Write("/secret/apps", "destination", config.OrphansReport) },
+			want: map[string]string{
+				"secret/data/apps":       "create read update",
+				"secret/data/apps/*":     "create read update",
+				"secret/metadata/apps":   "list",
+				"secret/metadata/apps/*": "list",
+				"sys/mounts":             "read",
+			},
+		},
+		{
+			name:      "write kv v2 removing orphans",
+			kvVersion: "2",
+			grant:     func(p *Policy) error { return p.Write("/secret/apps", "destination", config.OrphansRemove) },
+			want: map[string]string{
+				"secret/data/apps":       "create read update",
+				"secret/data/apps/*":     "create read update",
+				"secret/metadata/apps":   "delete list",
+				"secret/metadata/apps/*": "delete list",
+				"sys/mounts":             "read",
+			},
+		},
+		{
+			name:      "write kv v1 removing orphans",
+			kvVersion: "1",
+			grant:     func(p *Policy) error { return p.Write("/secret/apps", "destination", config.OrphansRemove) },
+			want: map[string]string{
+				"secret/apps":   "create read update delete list",
+				"secret/apps/*": "create read update delete list",
+				"sys/mounts":    "read",
+			},
+		},
+		{
+			name:      "append kv v2",
+			kvVersion: "2",
+			grant:     func(p *Policy) error { return p.Append("/secret/vsync/journal", "journal") },
+			want: map[string]string{
+				"secret/data/vsync/journal":       "create read",
+				"secret/data/vsync/journal/*":     "create read",
+				"secret/metadata/vsync/journal":   "list",
+				"secret/metadata/vsync/journal/*": "list",
+				"sys/mounts":                      "read",
+			},
+		},
+		{
+			name:      "annotate kv v2",
+			kvVersion: "2",
+			grant:     func(p *Policy) error { return p.Annotate("/secret/apps", "promotion metadata") },
+			want: map[string]string{
+				"secret/metadata/apps":   "create read update",
+				"secret/metadata/apps/*": "create read update",
+				"sys/mounts":             "read",
+			},
+		},
+		{
+			name:      "annotate kv v1",
+			kvVersion: "1",
+			grant:     func(p *Policy) error { return p.Annotate("/secret/apps", "promotion metadata") },
+			want:      map[string]string{"sys/mounts": "read"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, closeVault := testPolicy(t, test.kvVersion)
+			defer closeVault()
+
+			if err := test.grant(p); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for rulePath, rule := range p.rules {
+				var capabilities []string
+				for _, capability := range capabilityOrder {
+					if rule.capabilities[capability] {
+						capabilities = append(capabilities, capability)
+					}
+				}
+				got[rulePath] = strings.Join(capabilities, " ")
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("paths %v, want %v", got, test.want)
+			}
+			for rulePath, capabilities := range test.want {
+				if got[rulePath] != capabilities {
+					t.Errorf("path %s capabilities %q, want %q", rulePath, got[rulePath], capabilities)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyNoMount(t *testing.T) {
+	p, closeVault := testPolicy(t, "2")
+	defer closeVault()
+
+	err := p.Read("/other/apps", "source")
+	if err == nil || !strings.Contains(err.Error(), "no mount found for /other/apps") {
+		t.Errorf("Read() = %v, want no mount found", err)
+	}
+}
+
+func TestPolicyHCL(t *testing.T) {
+	p, closeVault := testPolicy(t, "2")
+	defer closeVault()
+
+	if err := p.Write("/secret/apps", "destination", config.OrphansRemove); err != nil {
+		t.Fatal(err)
+	}
+	p.Namespaces("recursive namespaces")
+
+	want := `# vsync, generated by vsync policy generate
+# syncs namespaces recursively, create the policy in each of them too
+
+# destination
+path "secret/data/apps" {
+  capabilities = ["create", "read", "update"]
+}
+
+# destination
+path "secret/data/apps/*" {
+  capabilities = ["create", "read", "update"]
+}
+
+# destination orphans
+path "secret/metadata/apps" {
+  capabilities = ["delete", "list"]
+}
+
+# destination orphans
+path "secret/metadata/apps/*" {
+  capabilities = ["delete", "list"]
+}
+
+# kv versions of the mounts
+path "sys/mounts" {
+  capabilities = ["read"]
+}
+
+# recursive namespaces
+path "sys/namespaces/*" {
+  capabilities = ["list"]
+}
+`
+	if got := p.HCL(); got != want {
+		t.Errorf("HCL() =\n%s\nwant\n%s", got, want)
+	}
+}
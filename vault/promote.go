@@ -0,0 +1,284 @@
+package vault
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// changes of a key in a promotion
+	KeyAdded     = "added"
+	KeyChanged   = "changed"
+	KeyRemoved   = "removed"
+	KeyUnchanged = "unchanged"
+
+	// custom metadata of a kv2 destination secret recording its promotion
+	promotedFrom    = "vsync_promoted_from"
+	promotedAt      = "vsync_promoted_at"
+	promotedKeys    = "vsync_promoted_keys"
+	promotedVersion = "vsync_promoted_version"
+)
+
+// Promotion is what promoting an app's secrets from one environment to another
+// changes, the values to write are kept out of its json
+type Promotion struct {
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	Keys    []string          `json:"keys,omitempty"`
+	Secrets []*PromotedSecret `json:"secrets"`
+}
+
+// PromotedSecret is a secret of the app and the change of each of its keys
+type PromotedSecret struct {
+	SourcePath      string         `json:"source_path"`
+	DestinationPath string         `json:"destination_path"`
+	Keys            []*PromotedKey `json:"keys"`
+
+	values        map[string]interface{}
+	previous      *api.Secret
+	sourceVersion int
+}
+
+// PromotedKey is the change of a key, with hashes in place of its values, the
+// hashes are keyed for each promotion so they only compare values within it
+type PromotedKey struct {
+	Name            string `json:"name"`
+	Change          string `json:"change"`
+	SourceHash      string `json:"source_hash,omitempty"`
+	DestinationHash string `json:"destination_hash,omitempty"`
+}
+
+// PlanPromotion compares the secrets below the source entrypoint, or only the
+// secret at a path relative to it, with the same paths below the destination
+// entrypoint, for all their keys or only the keys given
+func (v *Client) PlanPromotion(appConfig *config.AppConfig, from, to, secret string, keys []string) (*Promotion, error) {
+	hashKey := make([]byte, 32)
+	if _, err := rand.Read(hashKey); err != nil {
+		return nil, err
+	}
+
+	sourceRoot := normalizeVaultPath(appConfig.Source.VaultEntrypoint)
+	relatives := []string{secret}
+	if len(secret) < 1 {
+		secretPaths, err := getSecretPaths(appConfig.Source.Client, sourceRoot)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the secrets below %s: %s", sourceRoot, err)
+		}
+		// without secrets below it, the path is the app's secret itself
+		if len(secretPaths) > 0 {
+			relatives = nil
+		}
+		for _, secretPath := range secretPaths {
+			relatives = append(relatives, strings.TrimPrefix(secretPath, sourceRoot))
+		}
+		sort.Strings(relatives)
+	}
+
+	p := &Promotion{From: from, To: to, Keys: keys}
+	found := make(map[string]bool)
+	for _, relative := range relatives {
+		s := &PromotedSecret{
+			SourcePath:      path.Join(sourceRoot, relative),
+			DestinationPath: path.Join(normalizeVaultPath(appConfig.Destination.VaultEntrypoint), relative),
+		}
+		source, err := v.ReadSecret(appConfig, s.SourcePath, false)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s from %s: %s", s.SourcePath, from, err)
+		}
+		s.sourceVersion = secretVersion(source)
+		// the destination secret may not exist yet, but when it can not be read
+		// its keys that are not promoted would be lost
+		s.previous, err = v.ReadSecret(appConfig, s.DestinationPath, true)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("unable to read %s from %s: %s", s.DestinationPath, to, err)
+		}
+
+		sourceValues, destinationValues := secretData(source), secretData(s.previous)
+		names := keys
+		s.values = make(map[string]interface{})
+		if len(keys) < 1 {
+			names = nil
+			for name := range sourceValues {
+				names = append(names, name)
+			}
+			for name := range destinationValues {
+				if _, ok := sourceValues[name]; !ok {
+					names = append(names, name)
+				}
+			}
+			for name, value := range sourceValues {
+				s.values[name] = value
+			}
+		} else {
+			for name, value := range destinationValues {
+				s.values[name] = value
+			}
+			var present []string
+			for _, name := range keys {
+				if value, ok := sourceValues[name]; ok {
+					s.values[name] = value
+					present = append(present, name)
+					found[name] = true
+				}
+			}
+			// secrets with none of the keys are not promoted
+			if len(present) < 1 {
+				continue
+			}
+			names = present
+		}
+
+		sort.Strings(names)
+		for _, name := range names {
+			s.Keys = append(s.Keys, promotedKey(hashKey, name, s.values, destinationValues))
+		}
+		p.Secrets = append(p.Secrets, s)
+	}
+
+	for _, name := range keys {
+		if !found[name] {
+			return nil, fmt.Errorf("no secret of %s in %s has the key %s", sourceRoot, from, name)
+		}
+	}
+
+	return p, nil
+}
+
+// Changed returns true when the secret needs to be written
+func (s *PromotedSecret) Changed() bool {
+	for _, key := range s.Keys {
+		if key.Change != KeyUnchanged {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Changed returns the secrets of the promotion that need to be written
+func (p *Promotion) Changed() (secrets []*PromotedSecret) {
+	for _, s := range p.Secrets {
+		if s.Changed() {
+			secrets = append(secrets, s)
+		}
+	}
+
+	return secrets
+}
+
+// Promote writes the changed secrets of a promotion to the destination, recording
+// where each was promoted from in its custom metadata when the destination is kv v2
+func (v *Client) Promote(appConfig *config.AppConfig, p *Promotion) {
+	for _, s := range p.Changed() {
+		if v.Stopped() {
+			return
+		}
+		if appConfig.DryRun {
+			log.Infof("dry run, skipping the promotion of %s", s.DestinationPath)
+			continue
+		}
+
+		written, err := writeSecret(appConfig.Destination.Client, s.DestinationPath, s.values)
+		if err != nil {
+			log.Errorf("failed to promote %s: %s", s.DestinationPath, err)
+			v.Report.Fail(namespacedPath(appConfig.Destination, s.DestinationPath), err)
+			continue
+		}
+		log.Infof("%s promoted to %s", s.SourcePath, s.DestinationPath)
+		if err := v.record(appConfig, s.SourcePath, s.DestinationPath, s.previous, s.values, written); err != nil {
+			log.Error(err)
+			v.Report.Fail(namespacedPath(appConfig.Destination, s.DestinationPath), err)
+			continue
+		}
+		v.Report.Change(namespacedPath(appConfig.Destination, s.DestinationPath))
+
+		if err := recordPromotion(appConfig.Destination.Client, p, s); err != nil {
+			log.Warnf("unable to record the promotion of %s in its custom metadata: %s", s.DestinationPath, err)
+		}
+	}
+}
+
+// recordPromotion keeps where a secret was promoted from, when and which of its
+// keys in the custom metadata of the destination secret, keeping the rest of it
+func recordPromotion(client *api.Client, p *Promotion, s *PromotedSecret) error {
+	if !isKV2(client, s.DestinationPath) {
+		return errors.New("custom metadata needs a kv v2 destination")
+	}
+
+	metadataPath := kvPath(client, s.DestinationPath, "metadata")
+	custom := make(map[string]interface{})
+	metadata, err := client.Logical().Read(metadataPath)
+	if err != nil {
+		return err
+	}
+	if metadata != nil {
+		if existing, ok := metadata.Data["custom_metadata"].(map[string]interface{}); ok {
+			for k, value := range existing {
+				custom[k] = value
+			}
+		}
+	}
+
+	keys := "*"
+	if len(p.Keys) > 0 {
+		keys = strings.Join(p.Keys, ",")
+	}
+	custom[promotedFrom] = p.From + ":" + s.SourcePath
+	custom[promotedAt] = time.Now().UTC().Format(time.RFC3339)
+	custom[promotedKeys] = keys
+	delete(custom, promotedVersion)
+	if s.sourceVersion > 0 {
+		custom[promotedVersion] = fmt.Sprint(s.sourceVersion)
+	}
+
+	_, err = client.Logical().Write(metadataPath, map[string]interface{}{"custom_metadata": custom})
+	return err
+}
+
+// promotedKey returns the change of a key from the destination's current values to the promoted values
+func promotedKey(hashKey []byte, name string, values, current map[string]interface{}) *PromotedKey {
+	key := &PromotedKey{Name: name}
+	value, promoted := values[name]
+	previous, exists := current[name]
+	if promoted {
+		key.SourceHash = valueHash(hashKey, value)
+	}
+	if exists {
+		key.DestinationHash = valueHash(hashKey, previous)
+	}
+
+	switch {
+	case !promoted:
+		key.Change = KeyRemoved
+	case !exists:
+		key.Change = KeyAdded
+	case key.SourceHash != key.DestinationHash:
+		key.Change = KeyChanged
+	default:
+		key.Change = KeyUnchanged
+	}
+
+	return key
+}
+
+// valueHash returns a short keyed hash of a secret value, which tells values apart without revealing them
+func valueHash(hashKey []byte, value interface{}) string {
+	data, _ := json.Marshal(value)
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil))[:12]
+}
@@ -0,0 +1,170 @@
+package vault
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// promotionSource is a stand-in source vault with the secret apps/db at version 3
+func promotionSource() *kvResponses {
+	return &kvResponses{responses: map[string]string{
+		"GET /v1/secret/metadata/apps": `{"data": {"keys": ["db"]}}`,
+		"GET /v1/secret/data/apps/db":  `{"data": {"data": {"user": "app", "password": "new"}, "metadata": {"version": 3}}}`,
+	}}
+}
+
+func TestPlanPromotion(t *testing.T) {
+	destinationSecret := `{"data": {"data": {"password": "old", "host": "db.prod"}, "metadata": {"version": 1}}}`
+	tests := []struct {
+		name        string
+		keys        []string
+		destination map[string]string
+		statuses    map[string]int
+		want        map[string]string
+		wantValues  map[string]interface{}
+		wantErr     string
+	}{
+		{
+			name:        "all keys",
+			destination: map[string]string{"GET /v1/secret/data/apps/db": destinationSecret},
+			want:        map[string]string{"user": KeyAdded, "password": KeyChanged, "host": KeyRemoved},
+			wantValues:  map[string]interface{}{"user": "app", "password": "new"},
+		},
+		{
+			name:        "keys given",
+			keys:        []string{"password"},
+			destination: map[string]string{"GET /v1/secret/data/apps/db": destinationSecret},
+			want:        map[string]string{"password": KeyChanged},
+			wantValues:  map[string]interface{}{"password": "new", "host": "db.prod"},
+		},
+		{
+			name:       "new secret",
+			want:       map[string]string{"user": KeyAdded, "password": KeyAdded},
+			wantValues: map[string]interface{}{"user": "app", "password": "new"},
+		},
+		{
+			name:    "missing key",
+			keys:    []string{"token"},
+			wantErr: "no secret of /secret/apps in staging has the key token",
+		},
+		{
+			name:     "unreadable destination",
+			statuses: map[string]int{"GET /v1/secret/data/apps/db": http.StatusForbidden},
+			wantErr:  "unable to read /secret/apps/db from prod",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destination := &kvResponses{responses: test.destination, statuses: test.statuses}
+			appConfig, closeAll := stubKVs(t, promotionSource(), destination)
+			defer closeAll()
+
+			v := &Client{Report: NewReport("", false)}
+			p, err := v.PlanPromotion(appConfig, "staging", "prod", "", test.keys)
+			if len(test.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("PlanPromotion() error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Secrets) != 1 || p.Secrets[0].DestinationPath != "/secret/apps/db" {
+				t.Fatalf("planned %v secret(s), want /secret/apps/db", len(p.Secrets))
+			}
+
+			s := p.Secrets[0]
+			got := make(map[string]string)
+			for _, key := range s.Keys {
+				got[key.Name] = key.Change
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("key changes %v, want %v", got, test.want)
+			}
+			for name, change := range test.want {
+				if got[name] != change {
+					t.Errorf("key %s %q, want %q", name, got[name], change)
+				}
+			}
+			if len(s.values) != len(test.wantValues) {
+				t.Errorf("values %v, want %v", s.values, test.wantValues)
+			}
+			for name, value := range test.wantValues {
+				if s.values[name] != value {
+					t.Errorf("value of %s %v, want %v", name, s.values[name], value)
+				}
+			}
+		})
+	}
+}
+
+func TestPromote(t *testing.T) {
+	tests := []struct {
+		name         string
+		kvVersion    string
+		wantWrite    string
+		wantMetadata bool
+	}{
+		{name: "kv v1", kvVersion: "1", wantWrite: "PUT /v1/secret/apps/db"},
+		{name: "kv v2", kvVersion: "2", wantWrite: "PUT /v1/secret/data/apps/db", wantMetadata: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destination := &kvResponses{kvVersion: test.kvVersion, responses: map[string]string{
+				test.wantWrite:                    `{"data": {"version": 2}}`,
+				"PUT /v1/secret/metadata/apps/db": `{}`,
+				"GET /v1/secret/metadata/apps/db": `{"data": {"custom_metadata": {"owner": "payments", "vsync_promoted_version": "1"}}}`,
+			}}
+			appConfig, closeAll := stubKVs(t, promotionSource(), destination)
+			defer closeAll()
+
+			v := &Client{Report: NewReport("", false)}
+			p, err := v.PlanPromotion(appConfig, "staging", "prod", "db", []string{"password"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.Promote(appConfig, p)
+
+			if !destination.made(test.wantWrite) {
+				t.Fatalf("the secret was not written with %s", test.wantWrite)
+			}
+			values := destination.body(test.wantWrite)
+			if test.kvVersion == "2" {
+				values, _ = values["data"].(map[string]interface{})
+			}
+			if values["password"] != "new" {
+				t.Errorf("wrote %v, want the promoted password", destination.body(test.wantWrite))
+			}
+			if len(v.Report.Changed) != 1 || len(v.Report.Failed) != 0 {
+				t.Errorf("reported %v changed and %v failed, want the secret changed", v.Report.Changed, v.Report.Failed)
+			}
+
+			wroteMetadata := destination.made("PUT /v1/secret/metadata/apps/db")
+			if wroteMetadata != test.wantMetadata {
+				t.Fatalf("custom metadata written %v, want %v", wroteMetadata, test.wantMetadata)
+			}
+			if !wroteMetadata {
+				return
+			}
+			custom, _ := destination.body("PUT /v1/secret/metadata/apps/db")["custom_metadata"].(map[string]interface{})
+			want := map[string]interface{}{
+				"owner":                  "payments",
+				"vsync_promoted_from":    "staging:/secret/apps/db",
+				"vsync_promoted_keys":    "password",
+				"vsync_promoted_version": "3",
+			}
+			for key, value := range want {
+				if custom[key] != value {
+					t.Errorf("custom metadata %s = %v, want %v", key, custom[key], value)
+				}
+			}
+			if custom["vsync_promoted_at"] == nil {
+				t.Error("custom metadata vsync_promoted_at is not set")
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package vault
+
+import (
+	"sync"
+	"time"
+)
+
+// Report is a summary of the secret paths changed, removed or failed in a run,
+// it never holds any secret values
+type Report struct {
+	Job      string    `json:"job,omitempty"`
+	DryRun   bool      `json:"dry_run"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Changed  []string  `json:"changed"`
+	Removed  []string  `json:"removed"`
+	Failed   []Failure `json:"failed"`
+	mutex    sync.Mutex
+}
+
+// Failure is a secret path that could not be sync'd or removed
+type Failure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// NewReport starts a report for a run of the given job
+func NewReport(job string, dryRun bool) *Report {
+	return &Report{
+		Job:     job,
+		DryRun:  dryRun,
+		Started: time.Now().UTC(),
+		Changed: []string{},
+		Removed: []string{},
+		Failed:  []Failure{},
+	}
+}
+
+// Change records a path that was written
+func (r *Report) Change(path string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Changed = append(r.Changed, path)
+}
+
+// Remove records a path that was removed
+func (r *Report) Remove(path string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Removed = append(r.Removed, path)
+}
+
+// Fail records a path that could not be sync'd or removed
+func (r *Report) Fail(path string, err error) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Failed = append(r.Failed, Failure{Path: path, Error: err.Error()})
+}
+
+// Finish marks the end of the run
+func (r *Report) Finish() {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Finished = time.Now().UTC()
+}
+
+// HasChanges returns true when any path was written or removed
+func (r *Report) HasChanges() bool {
+	return r != nil && len(r.Changed)+len(r.Removed) > 0
+}
+
+// HasFailures returns true when any path failed
+func (r *Report) HasFailures() bool {
+	return r != nil && len(r.Failed) > 0
+}
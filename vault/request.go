@@ -11,11 +11,7 @@ import (
 
 // Request performs a request with a vault client
 func (v *Client) Request(appConfig *config.AppConfig, destinationVault bool, method, uri string, body interface{}) (*http.Response, error) {
-	if destinationVault {
-		client = appConfig.Destination.Client
-	} else {
-		client = appConfig.Source.Client
-	}
+	client := getClient(appConfig, destinationVault)
 
 	url := fmt.Sprintf("/%s/%s", apiVersion, strings.TrimPrefix(uri, "/"))
 	log.Debugf("make request: %s %s, body: %#v", method, url, body)
@@ -0,0 +1,46 @@
+package vault
+
+import (
+	"strings"
+
+	"github.com/flaccid/vsync/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// DerivedSettings returns settings vsync derives from its configuration and
+// the vaults themselves, such as the resolved auth method and token accessor
+func (v *Client) DerivedSettings(appConfig *config.AppConfig) (settings []*config.Setting) {
+	sides := []struct {
+		name    string
+		service *config.VaultService
+	}{
+		{"source", appConfig.Source},
+		{"destination", appConfig.Destination},
+	}
+
+	for _, side := range sides {
+		if side.service == nil || side.service.Client == nil {
+			continue
+		}
+		add := func(name, value string) {
+			settings = append(settings, &config.Setting{Name: side.name + "-" + name, Value: value, Source: config.SourceDerived})
+		}
+
+		add("resolved-auth-method", authMethod(side.service))
+
+		token, err := side.service.Client.Auth().Token().LookupSelf()
+		if err != nil {
+			log.Debugf("unable to look up %s token: %s", side.name, err)
+			add("token-accessor", "unavailable")
+			continue
+		}
+		accessor, _ := token.TokenAccessor()
+		ttl, _ := token.TokenTTL()
+		policies, _ := token.TokenPolicies()
+		add("token-accessor", accessor)
+		add("token-ttl", ttl.String())
+		add("token-policies", strings.Join(policies, ","))
+	}
+
+	return settings
+}
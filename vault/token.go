@@ -0,0 +1,260 @@
+package vault
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// agentSinkInterval is how often the agent's sink file is checked for a new token
+	agentSinkInterval = 5 * time.Second
+
+	// loginRetryInterval is how long to wait before trying to log in again after a failure
+	loginRetryInterval = 10 * time.Second
+
+	// reloginFraction is the fraction of a token's ttl after which a token
+	// that cannot be renewed is replaced by logging in again
+	reloginFraction = 0.8
+)
+
+// errStopped is returned when the token watcher is stopped while logging in
+var errStopped = errors.New("token watcher stopped")
+
+// followers are the copies of each client, such as those made for namespaces,
+// that are given its token whenever it changes, see follow
+var followers = struct {
+	sync.Mutex
+	clients map[*api.Client]map[*api.Client]bool
+}{clients: make(map[*api.Client]map[*api.Client]bool)}
+
+// TokenWatcher keeps the token of a vault client valid for as long as vsync
+// runs, renewing it in the background and logging in again when it can no
+// longer be renewed
+type TokenWatcher struct {
+	target  string
+	service *config.VaultService
+	stopCh  chan struct{}
+	once    sync.Once
+}
+
+// WatchToken looks up the ttl of the service's token and, if it expires,
+// starts keeping it valid in the background until stopped, tokens from
+// vault agent are renewed by the agent and only re-read when they change
+func WatchToken(target string, service *config.VaultService) *TokenWatcher {
+	w := &TokenWatcher{
+		target:  target,
+		service: service,
+		stopCh:  make(chan struct{}),
+	}
+
+	if authMethod(service) == AuthAgent {
+		go w.watchSink()
+		return w
+	}
+
+	secret, err := w.lookup()
+	if err != nil {
+		log.Warnf("unable to look up %s token, it will not be renewed: %s", target, err)
+		return w
+	}
+	if secret.Auth.LeaseDuration == 0 {
+		log.Debugf("%s token does not expire", target)
+		return w
+	}
+	go w.watch(secret)
+
+	return w
+}
+
+// Stop stops renewing the token
+func (w *TokenWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.stopCh)
+	})
+}
+
+// watch renews the token until it can no longer be renewed then logs in
+// again, starting over with the new token
+func (w *TokenWatcher) watch(secret *api.Secret) {
+	for {
+		ttl := time.Duration(secret.Auth.LeaseDuration) * time.Second
+		if secret.Auth.Renewable {
+			if stopped := w.renew(secret); stopped {
+				return
+			}
+		} else {
+			wait := time.Duration(float64(ttl) * reloginFraction)
+			log.Debugf("%s token is not renewable, logging in again in %s", w.target, wait)
+			select {
+			case <-w.stopCh:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		if authMethod(w.service) == AuthToken {
+			log.Warnf("%s token can not be renewed further and will expire, use an auth method other than token to have vsync log in again", w.target)
+			return
+		}
+
+		var err error
+		secret, err = w.relogin()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// watchSink sets the token on the client whenever the agent writes a new one to its sink
+func (w *TokenWatcher) watchSink() {
+	client := w.service.Client
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-time.After(agentSinkInterval):
+		}
+
+		token, err := readSinkToken(w.service)
+		if err != nil {
+			log.Warnf("unable to read %s token from agent: %s", w.target, err)
+			continue
+		}
+		if token != client.Token() {
+			log.Infof("agent wrote a new %s token to %s", w.target, w.service.AgentSinkFile)
+			setToken(client, token)
+		}
+	}
+}
+
+// renew renews the token with the api renewer until renewal is exhausted,
+// returning true when the watcher was stopped
+func (w *TokenWatcher) renew(secret *api.Secret) bool {
+	client := w.service.Client
+	renewer, err := client.NewRenewer(&api.RenewerInput{Secret: secret})
+	if err != nil {
+		log.Errorf("unable to renew %s token: %s", w.target, err)
+		return false
+	}
+	go renewer.Renew()
+	defer renewer.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return true
+		case err := <-renewer.DoneCh():
+			if err != nil {
+				log.Warnf("renewing %s token failed: %s", w.target, err)
+			} else {
+				log.Infof("%s token has reached its max ttl", w.target)
+			}
+			return false
+		case renewal := <-renewer.RenewCh():
+			log.Debugf("renewed %s token, ttl %vs", w.target, renewal.Secret.Auth.LeaseDuration)
+		}
+	}
+}
+
+// relogin logs in again with the service's auth method, retrying until
+// it succeeds or the watcher is stopped
+func (w *TokenWatcher) relogin() (*api.Secret, error) {
+	for {
+		log.Infof("logging in to %s vault again", w.target)
+		secret, err := w.login()
+		if err == nil {
+			return secret, nil
+		}
+		log.Errorf("unable to log in to %s vault, retrying in %s: %s", w.target, loginRetryInterval, err)
+
+		select {
+		case <-w.stopCh:
+			return nil, errStopped
+		case <-time.After(loginRetryInterval):
+		}
+	}
+}
+
+// login logs in with the service's auth method and looks up the new token
+func (w *TokenWatcher) login() (*api.Secret, error) {
+	if err := login(w.service.Client, w.service); err != nil {
+		return nil, err
+	}
+
+	return w.lookup()
+}
+
+// lookup returns the client's current token as an auth secret the renewer can use
+func (w *TokenWatcher) lookup() (*api.Secret, error) {
+	client := w.service.Client
+	token, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := token.TokenTTL()
+	if err != nil {
+		return nil, err
+	}
+	renewable, _ := token.TokenIsRenewable()
+	if ttl > 0 {
+		log.Infof("%s token expires in %s (renewable: %v)", w.target, ttl, renewable)
+	}
+
+	return &api.Secret{
+		Auth: &api.SecretAuth{
+			ClientToken:   client.Token(),
+			Renewable:     renewable,
+			LeaseDuration: int(ttl.Seconds()),
+		},
+	}, nil
+}
+
+// setToken sets the token on a client and every copy following it
+func setToken(client *api.Client, token string) {
+	client.SetToken(token)
+
+	followers.Lock()
+	copies := make([]*api.Client, 0, len(followers.clients[client]))
+	for c := range followers.clients[client] {
+		copies = append(copies, c)
+	}
+	followers.Unlock()
+
+	for _, c := range copies {
+		setToken(c, token)
+	}
+}
+
+// follow gives the copy of a client its token, now and whenever the
+// client logs in again or its token is replaced, until unfollowed
+func follow(client, clone *api.Client) {
+	followers.Lock()
+	defer followers.Unlock()
+
+	if followers.clients[client] == nil {
+		followers.clients[client] = make(map[*api.Client]bool)
+	}
+	followers.clients[client][clone] = true
+	clone.SetToken(client.Token())
+}
+
+// unfollow stops giving copies of clients their tokens, once they are no longer used
+func unfollow(copies ...*api.Client) {
+	followers.Lock()
+	defer followers.Unlock()
+
+	for _, c := range copies {
+		for client, clients := range followers.clients {
+			delete(clients, c)
+			if len(clients) < 1 {
+				delete(followers.clients, client)
+			}
+		}
+		delete(followers.clients, c)
+	}
+}
@@ -0,0 +1,118 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/hcl"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultTokenFile is where the vault cli keeps its token, relative to the home directory
+	defaultTokenFile = ".vault-token"
+
+	// defaultCLIConfigFile is the vault cli's config file, relative to the home directory
+	defaultCLIConfigFile = ".vault"
+)
+
+// cliConfig is the part of the vault cli's config file vsync uses
+type cliConfig struct {
+	TokenHelper string `hcl:"token_helper"`
+}
+
+// discoverToken returns the token for a vault the way the vault cli finds
+// one: the token given by flag or env var, then the token file, then the
+// token helper from the vault cli's config, which defaults to ~/.vault-token
+func discoverToken(service *config.VaultService) (string, error) {
+	if len(service.VaultToken) > 0 {
+		return strings.TrimSpace(service.VaultToken), nil
+	}
+
+	if len(service.VaultTokenFile) > 0 {
+		log.Debugf("reading token for %s from %s", service.Vault.Address, service.VaultTokenFile)
+		token, err := valueOrFile("", service.VaultTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read token file: %s", err)
+		}
+		return token, nil
+	}
+
+	helper, err := tokenHelper()
+	if err != nil {
+		return "", err
+	}
+	if len(helper) > 0 {
+		log.Debugf("getting token for %s from token helper %s", service.Vault.Address, helper)
+		return runTokenHelper(helper, service.Vault.Address)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to detect home directory: %s", err)
+	}
+	token, err := valueOrFile("", filepath.Join(homeDir, defaultTokenFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read token file: %s", err)
+	}
+	log.Debugf("read token for %s from %s", service.Vault.Address, filepath.Join(homeDir, defaultTokenFile))
+
+	return token, nil
+}
+
+// tokenHelper returns the token helper set in the vault cli's config, from
+// VAULT_CONFIG_PATH or ~/.vault
+func tokenHelper() (string, error) {
+	path := os.Getenv("VAULT_CONFIG_PATH")
+	if len(path) < 1 {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to detect home directory: %s", err)
+		}
+		path = filepath.Join(homeDir, defaultCLIConfigFile)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read vault cli config: %s", err)
+	}
+
+	var c cliConfig
+	if err := hcl.Decode(&c, string(data)); err != nil {
+		return "", fmt.Errorf("unable to parse vault cli config %s: %s", path, err)
+	}
+
+	return c.TokenHelper, nil
+}
+
+// runTokenHelper gets the token for the vault address from a token helper program
+func runTokenHelper(helper, address string) (string, error) {
+	path, err := exec.LookPath(helper)
+	if err != nil {
+		return "", fmt.Errorf("unable to find token helper %s: %s", helper, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, "get")
+	// helpers that keep a token per vault select it by address
+	cmd.Env = append(os.Environ(), "VAULT_ADDR="+address)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("token helper %s failed: %s: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
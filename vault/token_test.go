@@ -0,0 +1,44 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestNamespaceClientsFollowToken(t *testing.T) {
+	client, err := api.NewClient(&api.Config{Address: "http://127.0.0.1:8200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken("first")
+
+	child, err := inNamespace(client, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grandchild, err := inNamespace(child, "team-a/payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.Token() != "first" || grandchild.Token() != "first" {
+		t.Fatalf("namespace tokens %q and %q, want the client's token", child.Token(), grandchild.Token())
+	}
+
+	// logging in again, or a new agent token, replaces the token of the client
+	setToken(client, "second")
+	if child.Token() != "second" || grandchild.Token() != "second" {
+		t.Errorf("namespace tokens %q and %q after a new token, want %q", child.Token(), grandchild.Token(), "second")
+	}
+
+	unfollow(grandchild, child)
+	setToken(client, "third")
+	if child.Token() != "second" {
+		t.Errorf("namespace token %q after unfollowing, want it unchanged", child.Token())
+	}
+	followers.Lock()
+	defer followers.Unlock()
+	if len(followers.clients) > 0 {
+		t.Errorf("%v client(s) still followed", len(followers.clients))
+	}
+}
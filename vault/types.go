@@ -3,7 +3,15 @@ package vault
 import "github.com/hashicorp/vault/api"
 
 type Client struct {
-	Client *api.Client
+	Client  *api.Client
+	Journal *Journal
+	Report  *Report
+
+	// Stop, when closed, stops a sync or orphan removal at the next secret path
+	Stop <-chan struct{}
+
+	// journalErr is why a change could not be journaled, which stops the run the same way
+	journalErr error
 }
 
 type Secret struct {
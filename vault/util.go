@@ -3,8 +3,8 @@ package vault
 import (
 	"encoding/json"
 	"fmt"
-	"path"
 	"reflect"
+	"sort"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -12,30 +12,19 @@ import (
 	"github.com/hashicorp/vault/api"
 )
 
-var (
-	totalSecrets        int
-	totalSecretsFolders int
-	childPaths          []string
-)
-
 // writeSecret writes a single secret to the provided vault
 // a private function that requires providing your vault api client
 // supports generic and kv engines only
-func writeSecret(v *api.Client, path string, data map[string]interface{}) error {
+func writeSecret(v *api.Client, path string, data map[string]interface{}) (*api.Secret, error) {
 	// update path and payload if engine is kv2
-	if engineType(v, path) == "kv" {
-		path = strings.Replace(path, "/secret", "/secret/data", 1)
+	if isKV2(v, path) {
+		path = kvPath(v, path, "data")
 		data = map[string]interface{}{"data": data}
 	}
 
 	// finally, write the secret
 	log.Debugf("write the secret to %s with [redacted]", path)
-	_, err := v.Logical().Write(path, data)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return v.Logical().Write(path, data)
 }
 
 // getClient returns source or destionation vault client depending on boolean provided
@@ -85,14 +74,26 @@ func dumpNode(v *api.Client, path string) {
 // syncNode iterates on a secret path on source to sync to destination
 func syncNode(v *Client, appConfig *config.AppConfig, path string) {
 	// get the secrets list at the entrypoint path
-	secretsList, err := appConfig.Source.Client.Logical().List(path)
+	listPath := path
+	if isKV2(appConfig.Source.Client, path) {
+		listPath = kvPath(appConfig.Source.Client, path, "metadata")
+	}
+	secretsList, err := appConfig.Source.Client.Logical().List(listPath)
 	if err != nil {
-		log.Fatal(err)
+		log.Error(err)
+		v.Report.Fail(namespacedPath(appConfig.Source, path), err)
+		return
+	}
+	if secretsList == nil {
+		return
 	}
 
 	for a, b := range secretsList.Data {
-		log.Debugf("initial crawl data", a, b)
+		log.Debugf("initial crawl data %s: %v", a, b)
 		for _, p := range b.([]interface{}) {
+			if v.Stopped() {
+				return
+			}
 			log.Debug("crawling...")
 			if p.(string)[len(p.(string))-1:] == "/" {
 				node := p.(string)
@@ -100,36 +101,21 @@ func syncNode(v *Client, appConfig *config.AppConfig, path string) {
 				syncNode(v, appConfig, path+"/"+node)
 			} else {
 				newPath := normalizeVaultPath(path + "/" + p.(string))
+				if !inScope(appConfig, newPath) {
+					log.Debugf("%s is not included in the sync", newPath)
+					continue
+				}
 				log.Debugf("sync secret at %s", newPath)
 
-				// get the secret from the source
-				secret, err := v.ReadSecret(appConfig, newPath, false)
+				synced, err := v.syncPath(appConfig, newPath)
 				if err != nil {
-					log.Panic(err)
-				}
-				// WARNING: insecure
-				log.Debugf("source secret data of %s: %s, err: %s", newPath, secret, err)
-
-				// get the secret from the destination, if it exists
-				destSecret, err := v.ReadSecret(appConfig, newPath, true)
-					if err != nil {
-					log.Debugf("%s: destination secret likely doesn't exist", err)
-					// WARNING: insecure
-					log.Debugf("secret: [%s], destSecret: [%s]", secret, destSecret)
-				} else {
-					// WARNING: insecure
-					log.Debugf("secret: [%s], destSecret: [%s]", secret, destSecret.Data)
+					log.Error(err)
+					v.Report.Fail(namespacedPath(appConfig.Source, newPath), err)
+					continue
 				}
-
-				// when the secret doesn't exist or the values are not the same
-				if destSecret == nil || ! reflect.DeepEqual(secret.Data, destSecret.Data["data"]) {
-					log.Debug("secret %s appears to need sync", newPath)
-					err := writeSecret(appConfig.Destination.Client, newPath, secret.Data)
-					if err != nil {
-						log.Fatal("failed to write secret", err)
-					}
-					log.Debugf("secret written to %s", newPath)
-					log.Infof("%s sync'd", newPath)
+				if synced {
+					log.Infof("%s sync'd", namespacedPath(appConfig.Source, newPath))
+					v.Report.Change(namespacedPath(appConfig.Source, newPath))
 				} else {
 					log.Debugf("%s already up-to-date", newPath)
 				}
@@ -138,9 +124,185 @@ func syncNode(v *Client, appConfig *config.AppConfig, path string) {
 	}
 }
 
+// syncPath syncs a single secret path from source to destination,
+// returning true when the destination needed to be written
+func (v *Client) syncPath(appConfig *config.AppConfig, path string) (bool, error) {
+	// get the secret from the source
+	secret, err := v.ReadSecret(appConfig, path, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to get secret %s from source vault: %s", path, err)
+	}
+	// WARNING: insecure
+	log.Debugf("source secret data of %s: %v", path, secret)
+
+	// get the secret from the destination, if it exists
+	destPath := destinationPath(appConfig, path)
+	if destPath != path {
+		log.Debugf("%s maps to %s in the destination", path, destPath)
+	}
+	destSecret, err := v.ReadSecret(appConfig, destPath, true)
+	if err != nil {
+		log.Debugf("%s: destination secret likely doesn't exist", err)
+	}
+	// WARNING: insecure
+	log.Debugf("secret: [%v], destSecret: [%v]", secret, destSecret)
+
+	// when the secret doesn't exist or the values are not the same
+	data := secretData(secret)
+	if destSecret != nil && reflect.DeepEqual(data, secretData(destSecret)) {
+		return false, nil
+	}
+
+	log.Debugf("secret %s appears to need sync", path)
+	if appConfig.DryRun {
+		log.Infof("dry run, skipping the write of %s", destPath)
+		return true, nil
+	}
+	written, err := writeSecret(appConfig.Destination.Client, destPath, data)
+	if err != nil {
+		return false, fmt.Errorf("failed to write secret %s: %s", destPath, err)
+	}
+	log.Debugf("secret written to %s", destPath)
+
+	if err := v.record(appConfig, path, destPath, destSecret, data, written); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// record adds a write to the journal, if one is in use
+// a change that cannot be journaled stops the run, no further changes are made
+func (v *Client) record(appConfig *config.AppConfig, sourcePath, destinationPath string, previous *api.Secret, data map[string]interface{}, written *api.Secret) error {
+	if v == nil || v.Journal == nil {
+		return nil
+	}
+
+	entry := &JournalEntry{
+		Job:             appConfig.Job,
+		SourcePath:      namespacedPath(appConfig.Source, sourcePath),
+		DestinationPath: namespacedPath(appConfig.Destination, destinationPath),
+		Action:          JournalActionCreate,
+		PreviousVersion: secretVersion(previous),
+		NewVersion:      secretVersion(written),
+	}
+	if previous != nil {
+		entry.Action = JournalActionUpdate
+	}
+	entry.KeysAdded, entry.KeysRemoved, entry.KeysChanged = keyDiff(secretData(previous), data)
+
+	if err := v.Journal.Record(entry); err != nil {
+		v.journalErr = fmt.Errorf("unable to journal write of %s: %s", destinationPath, err)
+		return v.journalErr
+	}
+
+	return nil
+}
+
+// recordDelete adds a removal to the journal, if one is in use
+func (v *Client) recordDelete(appConfig *config.AppConfig, destinationPath string, previous *api.Secret) error {
+	if v == nil || v.Journal == nil {
+		return nil
+	}
+
+	entry := &JournalEntry{
+		Job:             appConfig.Job,
+		DestinationPath: namespacedPath(appConfig.Destination, destinationPath),
+		Action:          JournalActionDelete,
+		PreviousVersion: secretVersion(previous),
+	}
+	_, entry.KeysRemoved, _ = keyDiff(secretData(previous), nil)
+
+	if err := v.Journal.Record(entry); err != nil {
+		v.journalErr = fmt.Errorf("unable to journal removal of %s: %s", destinationPath, err)
+		return v.journalErr
+	}
+
+	return nil
+}
+
+// secretData returns the key/values of a secret from either kv engine version
+func secretData(secret *api.Secret) map[string]interface{} {
+	if secret == nil {
+		return nil
+	}
+	if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return data
+		}
+	}
+	return secret.Data
+}
+
+// secretVersion returns the kv2 version of a read or written secret, 0 if unversioned
+func secretVersion(secret *api.Secret) int {
+	if secret == nil || secret.Data == nil {
+		return 0
+	}
+
+	version := secret.Data["version"]
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		version = metadata["version"]
+	}
+
+	switch n := version.(type) {
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	case float64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+// keyDiff returns the key names added, removed and changed between two sets of secret values
+func keyDiff(previous, current map[string]interface{}) (added, removed, changed []string) {
+	for k, v := range current {
+		old, ok := previous[k]
+		if !ok {
+			added = append(added, k)
+		} else if !reflect.DeepEqual(old, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	return added, removed, changed
+}
+
+// kvPath inserts a kv2 api segment (data or metadata) after the mount of a secret path
+func kvPath(v *api.Client, secretPath string, segment string) string {
+	secretPath = normalizeVaultPath("/" + secretPath)
+	mounts, err := getMounts(v)
+	if err != nil {
+		log.Errorf("error getting mounts: %s", err)
+	}
+	mount, _ := mountOf(mounts, secretPath)
+	if len(mount) < 1 {
+		return secretPath
+	}
+
+	mountPath := "/" + strings.TrimSuffix(mount, "/")
+	return normalizeVaultPath(mountPath + "/" + segment + "/" + strings.TrimPrefix(secretPath, mountPath))
+}
+
 // getMounts returns all mountpoints from the provided vault client
 func getMounts(v *api.Client) (mounts map[string]*api.MountOutput, err error) {
+	if mounts = cachedMountsOf(v); mounts != nil {
+		return mounts, nil
+	}
 	mounts, err = v.Sys().ListMounts()
+	if err == nil {
+		cacheMounts(v, mounts)
+	}
 	return mounts, err
 }
 
@@ -148,7 +310,7 @@ func getMounts(v *api.Client) (mounts map[string]*api.MountOutput, err error) {
 func getMount(v *api.Client, secretPath string) (mount string) {
 	mounts, err := getMounts(v)
 	if err != nil {
-		log.Errorf("error getting mounts: ", err)
+		log.Errorf("error getting mounts: %s", err)
 	}
 
 	// NOTE: only currently supports one folder depth mountpoints
@@ -166,16 +328,25 @@ func getMount(v *api.Client, secretPath string) (mount string) {
 
 // getSecretPaths iterates on a secret path and returns all secret paths found within
 func getSecretPaths(v *api.Client, secretPath string) (secretPaths []string, err error) {
-	mountPoint := getMount(v, secretPath)
-	//log.Debugf("acton on %s in %s", secretPath, mountPoint)
+	err = walkSecretPaths(v, secretPath, &secretPaths)
+	return secretPaths, err
+}
+
+// walkSecretPaths appends the secret paths found within a secret path
+func walkSecretPaths(v *api.Client, secretPath string, secretPaths *[]string) error {
 	//log.Debugf("walk %s", path)
 
-	// assumes kv v2 engine
-	listPath := normalizeVaultPath(strings.Replace(secretPath, "/"+path.Clean(mountPoint), "/"+path.Clean(mountPoint)+"/metadata", 1))
+	listPath := secretPath
+	if isKV2(v, secretPath) {
+		listPath = kvPath(v, secretPath, "metadata")
+	}
 	//log.Debugf("list path %s", listPath)
 	secretsList, err := v.Logical().List(listPath)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if secretsList == nil {
+		return nil
 	}
 	//log.Debugf("secretsList keys", secretsList.Data["keys"])
 
@@ -186,33 +357,44 @@ func getSecretPaths(v *api.Client, secretPath string) (secretPaths []string, err
 				// is a path/folder
 				node := normalizeVaultPath(secretPath + "/" + p.(string))
 				//log.Debugf("found node %s", node)
-				totalSecretsFolders = totalSecretsFolders + 1
-				getSecretPaths(v, node)
+				if err := walkSecretPaths(v, node, secretPaths); err != nil {
+					return err
+				}
 			} else {
 				// is a secret
 				p := normalizeVaultPath(secretPath + "/" + p.(string))
 				//log.Debugf("found secret %s", p)
-				totalSecrets = totalSecrets + 1
-				childPaths = append(childPaths, p)
+				*secretPaths = append(*secretPaths, p)
 			}
 		}
 	}
 
-	secretPaths = childPaths
-	return secretPaths, err
+	return nil
+}
+
+// isKV2 returns true when a secret path is in a kv version 2 mount, which keeps
+// the data and metadata of its secrets below separate api paths
+func isKV2(v *api.Client, secretPath string) bool {
+	mounts, err := getMounts(v)
+	if err != nil {
+		log.Errorf("error getting mounts: %s", err)
+	}
+	_, mount := mountOf(mounts, secretPath)
+
+	return mount != nil && kvVersion(mount) == "2"
 }
 
 // engineType returns the engine type by mount of a given arbitrary secret path
 func engineType(v *api.Client, path string) (engineType string) {
 	mounts, err := getMounts(v)
 	if err != nil {
-		log.Errorf("error getting mounts: ", err)
+		log.Errorf("error getting mounts: %s", err)
 	}
 
 	// NOTE: only currently supports one folder depth mountpoints
 	mountPoint := strings.Split(normalizeVaultPath(path), "/")[1] + "/"
 	log.Debugf("mountpoint for %s = %s", path, mountPoint)
-	log.Debugf("check if %s is in ", mountPoint, mounts)
+	log.Debugf("check if %s is in %v", mountPoint, mounts)
 	for k := range mounts {
 		if k == mountPoint {
 			// get the engine type from the mountpoint
@@ -227,7 +409,7 @@ func engineType(v *api.Client, path string) (engineType string) {
 func ToJson(o interface{}) (j []byte, err error) {
 	j, err = json.MarshalIndent(o, "", "    ")
 	if err != nil {
-		log.Fatalf("error marshalling json: ", err.Error())
+		log.Fatalf("error marshalling json: %s", err)
 	}
 
 	return j, err
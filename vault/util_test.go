@@ -0,0 +1,57 @@
+package vault
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestKVPath(t *testing.T) {
+	server, service := stubVault(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {
+			"secret/": {"type": "kv", "options": {"version": "2"}},
+			"kv/": {"type": "kv", "options": {"version": "2"}},
+			"kv1/": {"type": "kv", "options": {"version": "1"}},
+			"legacy/": {"type": "kv"},
+			"generic/": {"type": "generic"},
+			"team/apps/": {"type": "kv", "options": {"version": "2"}}
+		}}`))
+	})
+	defer server.Close()
+	service.VaultToken = "token"
+	client, err := Connect(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		secretPath string
+		kv2        bool
+		data       string
+		metadata   string
+	}{
+		{secretPath: "/secret/app", kv2: true, data: "/secret/data/app", metadata: "/secret/metadata/app"},
+		{secretPath: "/kv/secretary/x", kv2: true, data: "/kv/data/secretary/x", metadata: "/kv/metadata/secretary/x"},
+		{secretPath: "kv/app/", kv2: true, data: "/kv/data/app/", metadata: "/kv/metadata/app/"},
+		{secretPath: "/team/apps/payments", kv2: true, data: "/team/apps/data/payments", metadata: "/team/apps/metadata/payments"},
+		{secretPath: "/kv1/app", kv2: false},
+		{secretPath: "/legacy/app", kv2: false},
+		{secretPath: "/generic/app", kv2: false},
+		{secretPath: "/unmounted/app", kv2: false},
+	}
+
+	for _, test := range tests {
+		if got := isKV2(client, test.secretPath); got != test.kv2 {
+			t.Errorf("isKV2(%s) = %v, want %v", test.secretPath, got, test.kv2)
+		}
+		if !test.kv2 {
+			continue
+		}
+		if got := kvPath(client, test.secretPath, "data"); got != test.data {
+			t.Errorf("data path of %s = %s, want %s", test.secretPath, got, test.data)
+		}
+		if got := kvPath(client, test.secretPath, "metadata"); got != test.metadata {
+			t.Errorf("metadata path of %s = %s, want %s", test.secretPath, got, test.metadata)
+		}
+	}
+}
@@ -2,8 +2,8 @@ package vault
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
-	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/flaccid/vsync/config"
@@ -14,8 +14,10 @@ const (
 	apiVersion = "v1"
 )
 
+// ErrNotFound is returned when there is no secret at a path
+var ErrNotFound = errors.New("no secret found")
+
 var (
-	client     *api.Client
 	entryPoint string
 	secretPath string
 )
@@ -27,47 +29,67 @@ func (v *Client) GetClient() *api.Client {
 
 // ReadSecret reads a single secret from the vault
 func (v *Client) ReadSecret(appConfig *config.AppConfig, path string, destinationVault bool) (*api.Secret, error) {
-	client = getClient(appConfig, destinationVault)
+	client := getClient(appConfig, destinationVault)
 
 	// read secret depending on secret engine version
-	if engineType(client, path) == "kv" {
-		path = strings.Replace(path, "/secret", "/secret/data", 1)
+	if isKV2(client, path) {
+		path = kvPath(client, path, "data")
 	}
 	secret, err := client.Logical().Read(normalizeVaultPath(path))
+	if err != nil {
+		return nil, err
+	}
 	if secret == nil {
-		return nil, errors.New("no secret found in " + path)
+		return nil, fmt.Errorf("%w in %s", ErrNotFound, path)
+	}
+
+	return secret, nil
+}
+
+// ReadSecretKey reads a single key of a secret from a vault service, such as a
+// credential another vault's config refers to
+func ReadSecretKey(service *config.VaultService, secretPath, key string) (string, error) {
+	v := &Client{}
+	secret, err := v.ReadSecret(&config.AppConfig{Source: service}, secretPath, false)
+	if err != nil {
+		return "", err
 	}
 
-	return secret, err
+	value, ok := secretData(secret)[key]
+	if !ok || value == nil {
+		return "", fmt.Errorf("secret %s has no key %s", secretPath, key)
+	}
+
+	return fmt.Sprint(value), nil
 }
 
 // WriteSecret writes a single secret to the vault
 func (v *Client) WriteSecret(appConfig *config.AppConfig, secret *Secret, destinationVault bool) error {
 	log.Debugf("write the secret to %s with %s", secret.Path, secret.Values)
-	client = getClient(appConfig, destinationVault)
+	client := getClient(appConfig, destinationVault)
 
 	// check if the secret data already exists and is the same
 	existingSecret, err := v.ReadSecret(appConfig, secret.Path, destinationVault)
 	if err != nil {
-		log.Debug("secret may not exist: %s", err)
+		log.Debugf("secret may not exist: %s", err)
 	}
-	log.Debugf("existing secret: %s", existingSecret)
+	log.Debugf("existing secret: %v", existingSecret)
 
 	// WARNING: insecure!
-	log.Debugf("comparing secret data: cmd[%s] and existing[%s]", secret.Values, existingSecret.Data)
+	log.Debugf("comparing secret data: cmd[%s] and existing[%v]", secret.Values, secretData(existingSecret))
 
 	// when the secret doesn't exist or the values are not the same
-	// TODO: below assumes the existing secret is of kv2!
-	if existingSecret == nil || ! reflect.DeepEqual(secret.Values, existingSecret.Data["data"]) {
+	if existingSecret == nil || ! reflect.DeepEqual(secret.Values, secretData(existingSecret)) {
 		log.Debug("secret appears to need sync")
-		err := writeSecret(client, secret.Path, secret.Values)
+		written, err := writeSecret(client, secret.Path, secret.Values)
 		if err != nil {
 			return err
 		}
 		log.Infof("secret written to %s", secret.Path)
-	} else {
-		log.Info("secret appears to be up to date, not writing")
+
+		return v.record(appConfig, "", secret.Path, existingSecret, secret.Values, written)
 	}
+	log.Info("secret appears to be up to date, not writing")
 
 	return nil
 }
@@ -76,21 +98,21 @@ func (v *Client) WriteSecret(appConfig *config.AppConfig, secret *Secret, destin
 func (v *Client) DeleteSecret(appConfig *config.AppConfig, secretPath string, destinationVault bool) error {
 	log.Debugf("delete the secret %s", secretPath)
 
-	client = getClient(appConfig, destinationVault)
+	client := getClient(appConfig, destinationVault)
 
-	secret, err := client.Logical().Delete(secretPath)
+	_, err := client.Logical().Delete(secretPath)
 	if err != nil {
 		return err
 	}
 
-	log.Infof("secret %s deleted", secret)
+	log.Infof("secret %s deleted", secretPath)
 
 	return nil
 }
 
 // ListSecrets lists secrets located at the provided path
 func (v *Client) ListSecrets(appConfig *config.AppConfig, destinationVault bool) {
-	client = getClient(appConfig, destinationVault)
+	client := getClient(appConfig, destinationVault)
 
 	secretsList, err := client.Logical().List(secretPath)
 	if err != nil {
@@ -104,7 +126,7 @@ func (v *Client) ListSecrets(appConfig *config.AppConfig, destinationVault bool)
 
 // ListVaultMounts lists the mounts within the vault
 func (v *Client) ListVaultMounts(appConfig *config.AppConfig, destinationVault bool) (vaultMounts map[string]*api.MountOutput, err error) {
-	client = getClient(appConfig, destinationVault)
+	client := getClient(appConfig, destinationVault)
 
 	mountsList, err := client.Sys().ListMounts()
 	if err != nil {
@@ -116,7 +138,7 @@ func (v *Client) ListVaultMounts(appConfig *config.AppConfig, destinationVault b
 
 // DumpSecrets dumps all the secrets within the vault recursiviely
 func (v *Client) DumpSecrets(appConfig *config.AppConfig, destinationVault bool) {
-	client = getClient(appConfig, destinationVault)
+	client := getClient(appConfig, destinationVault)
 
 	dumpNode(client, entryPoint)
 }
@@ -125,36 +147,14 @@ func (v *Client) DumpSecrets(appConfig *config.AppConfig, destinationVault bool)
 func (v *Client) SyncSecret(appConfig *config.AppConfig, path string) error {
 	log.Debugf("sync the secret %s", path)
 
-	// get the secret from the source
-	secret, err := v.ReadSecret(appConfig, path, false)
+	synced, err := v.syncPath(appConfig, path)
 	if err != nil {
-		log.Fatalf("failed to get secret %s from source vault", path, err)
-	}
-	if secret == nil {
-		log.Fatalf("secret %s appears to be empty or does not exist in the source vault", path)
-	}
-
-	// WARNING: insecure
-	log.Debug(secret, err)
-
-	// get the secret from the destination, if it exists
-	destSecret, err := v.ReadSecret(appConfig, path, true)
-		if err != nil {
-		log.Debugf("%s: destination secret likely doesn't exist", err)
+		v.Report.Fail(namespacedPath(appConfig.Source, path), err)
+		return err
 	}
-	// WARNING: insecure
-	log.Debug(destSecret, err)
-
-	// when the secret doesn't exist or the values are not the same
-	// TODO: below assumes the existing secret is of kv2!
-	if destSecret == nil || ! reflect.DeepEqual(secret.Data, destSecret.Data["data"]) {
-		log.Debug("secret appears to need sync")
-		err := writeSecret(appConfig.Destination.Client, path, secret.Data)
-		if err != nil {
-			log.Fatal("failed to write secret", err)
-		}
-		log.Debugf("secret written to %s", path)
+	if synced {
 		log.Infof("secret %s successfully sync'd", path)
+		v.Report.Change(namespacedPath(appConfig.Source, path))
 	} else {
 		log.Info("secret appears to be up to date, no sync required")
 	}
@@ -168,3 +168,24 @@ func (v *Client) SyncSecrets(appConfig *config.AppConfig) {
 	log.Debugf("sync from entrypoint %s", path)
 	syncNode(v, appConfig, path)
 }
+
+// Stopped returns true when the run was asked to stop, or a change could not be journaled
+func (v *Client) Stopped() bool {
+	if v.journalErr != nil {
+		return true
+	}
+	if v.Stop == nil {
+		return false
+	}
+	select {
+	case <-v.Stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// JournalErr returns why a change of the run could not be journaled, if one could not
+func (v *Client) JournalErr() error {
+	return v.journalErr
+}
@@ -0,0 +1,159 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/flaccid/vsync/config"
+	"github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// wrappedCreationPaths are the paths a wrapping token handed to vsync may be created by
+// when no creation path is configured, those that return a token or approle credentials
+var wrappedCreationPaths = []string{
+	"auth/token/create*",
+	"auth/approle/role/*/secret-id",
+	"auth/*/login*",
+	"sys/wrapping/wrap",
+}
+
+// wrapInfo is the result of looking up a wrapping token
+type wrapInfo struct {
+	CreationPath string
+	CreationTime time.Time
+	CreationTTL  time.Duration
+}
+
+// Remaining returns how long the wrapping token is valid for
+func (w *wrapInfo) Remaining() time.Duration {
+	return time.Until(w.CreationTime.Add(w.CreationTTL))
+}
+
+// loginWrapped replaces the service's wrapping token with the client token or
+// approle credentials it wraps, after checking the token was created by an
+// expected path and has not expired
+func loginWrapped(client *api.Client, service *config.VaultService) error {
+	wrappingToken := strings.TrimSpace(service.WrappedToken)
+	// the token is single use, it is never tried twice
+	service.WrappedToken = ""
+
+	info, err := lookupWrapped(client, wrappingToken)
+	if err != nil {
+		return err
+	}
+	log.Debugf("wrapping token created by %s at %s with ttl %s", info.CreationPath, info.CreationTime, info.CreationTTL)
+	if !creationPathAllowed(info.CreationPath, service.WrappedTokenCreationPath) {
+		return fmt.Errorf("wrapping token was created by %s, which is not an expected creation path, it may have been tampered with", info.CreationPath)
+	}
+	if info.Remaining() <= 0 {
+		return fmt.Errorf("wrapping token expired %s ago", -info.Remaining().Round(time.Second))
+	}
+
+	secret, err := unwrap(client, wrappingToken)
+	if err != nil {
+		return wrappingError(err)
+	}
+
+	switch {
+	case secret.Auth != nil && len(secret.Auth.ClientToken) > 0:
+		log.Infof("unwrapped a client token for %s", displayAddress(client))
+		service.AuthMethod = AuthToken
+		service.VaultToken = secret.Auth.ClientToken
+	case secret.Data["secret_id"] != nil:
+		log.Infof("unwrapped approle credentials for %s", displayAddress(client))
+		service.AuthMethod = AuthAppRole
+		service.SecretID = fmt.Sprint(secret.Data["secret_id"])
+		service.SecretIDFile = ""
+		service.SecretIDWrapped = false
+		if roleID, ok := secret.Data["role_id"].(string); ok && len(roleID) > 0 {
+			service.RoleID = roleID
+			service.RoleIDFile = ""
+		}
+	case secret.Data["token"] != nil:
+		log.Infof("unwrapped a client token for %s", displayAddress(client))
+		service.AuthMethod = AuthToken
+		service.VaultToken = fmt.Sprint(secret.Data["token"])
+	default:
+		return errors.New("wrapping token did not contain a client token or approle credentials")
+	}
+
+	return login(client, service)
+}
+
+// lookupWrapped returns the creation path, time and ttl of a wrapping token
+// without using it up
+func lookupWrapped(client *api.Client, wrappingToken string) (*wrapInfo, error) {
+	c, err := client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	c.SetHeaders(client.Headers())
+	c.ClearToken()
+
+	secret, err := c.Logical().Write("sys/wrapping/lookup", map[string]interface{}{
+		"token": wrappingToken,
+	})
+	if err != nil {
+		return nil, wrappingError(err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("wrapping token lookup returned no data")
+	}
+
+	info := &wrapInfo{}
+	info.CreationPath, _ = secret.Data["creation_path"].(string)
+	if created, ok := secret.Data["creation_time"].(string); ok {
+		info.CreationTime, err = time.Parse(time.RFC3339Nano, created)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse wrapping token creation time: %s", err)
+		}
+	}
+	if ttl, ok := secret.Data["creation_ttl"].(json.Number); ok {
+		seconds, err := ttl.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse wrapping token ttl: %s", err)
+		}
+		info.CreationTTL = time.Duration(seconds) * time.Second
+	}
+
+	return info, nil
+}
+
+// creationPathAllowed returns true when the creation path matches the expected
+// path, or any of the default paths when none is expected; * matches within a
+// path segment, except at the end of a path where it matches any suffix
+func creationPathAllowed(creationPath, expected string) bool {
+	patterns := wrappedCreationPaths
+	if len(expected) > 0 {
+		patterns = []string{expected}
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		expr := strings.Replace(regexp.QuoteMeta(pattern), `\*`, `[^/]*`, -1)
+		if strings.HasSuffix(pattern, "*") {
+			expr = strings.TrimSuffix(expr, `[^/]*`) + ".*"
+		}
+		if regexp.MustCompile("^" + expr + "$").MatchString(strings.Trim(creationPath, "/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wrappingError explains the error vault returns for a wrapping token that
+// does not exist, which is what a used token looks like
+func wrappingError(err error) error {
+	if strings.Contains(err.Error(), "wrapping token is not valid or does not exist") {
+		log.Error("the wrapping token has already been used or has expired, if vsync did not use it the token may have been intercepted, investigate before issuing another")
+		return fmt.Errorf("wrapping token is invalid, already used or expired: %s", err)
+	}
+
+	return err
+}
@@ -0,0 +1,34 @@
+package vault
+
+import "testing"
+
+func TestCreationPathAllowed(t *testing.T) {
+	tests := []struct {
+		creationPath string
+		expected     string
+		want         bool
+	}{
+		{creationPath: "auth/token/create", want: true},
+		{creationPath: "auth/token/create-orphan", want: true},
+		{creationPath: "auth/token/create/vsync", want: true},
+		{creationPath: "auth/approle/role/vsync/secret-id", want: true},
+		{creationPath: "/auth/approle/role/vsync/secret-id/", want: true},
+		{creationPath: "auth/approle/role/team/vsync/secret-id", want: false},
+		{creationPath: "auth/kubernetes/login", want: true},
+		{creationPath: "sys/wrapping/wrap", want: true},
+		{creationPath: "secret/data/app", want: false},
+		{creationPath: "sys/wrapping/rewrap", want: false},
+		{creationPath: "auth/approle/role/vsync/secret-id", expected: "auth/approle/role/vsync/secret-id", want: true},
+		{creationPath: "auth/token/create", expected: "auth/approle/role/vsync/secret-id", want: false},
+		{creationPath: "auth/approle/role/other/secret-id", expected: "auth/approle/role/*/secret-id", want: true},
+		{creationPath: "auth/approle/role/a/b/secret-id", expected: "auth/approle/role/*/secret-id", want: false},
+		{creationPath: "auth/userpass/login/ci", expected: "/auth/userpass/login*", want: true},
+		{creationPath: "auth/userpass.login", expected: "auth/userpass/login", want: false},
+	}
+
+	for _, test := range tests {
+		if got := creationPathAllowed(test.creationPath, test.expected); got != test.want {
+			t.Errorf("creationPathAllowed(%q, %q) = %v, want %v", test.creationPath, test.expected, got, test.want)
+		}
+	}
+}